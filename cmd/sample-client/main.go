@@ -5,12 +5,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	userup "github.com/hillside-labs/userservice-go-sdk/go-client"
 )
 
 func main() {
-	client, err := userup.NewClient("localhost:9000")
+	client, err := userup.NewClient("localhost:9000", userup.WithDefaultTimeout(10*time.Second))
 	if err != nil {
 		log.Fatal(err)
 	}
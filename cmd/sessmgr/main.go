@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/fatih/color"
 	userup "github.com/hillside-labs/userservice-go-sdk/go-client"
@@ -14,6 +15,10 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
+// callTimeout bounds each call to the user service so a stalled server
+// cannot hang the command.
+const callTimeout = 10 * time.Second
+
 func main() {
 
 	app := cli.App{
@@ -34,7 +39,7 @@ func main() {
 					},
 				},
 				Action: func(c *cli.Context) error {
-					client, err := userup.NewClient("localhost:9000")
+					client, err := userup.NewClient("localhost:9000", userup.WithDefaultTimeout(callTimeout))
 					if err != nil {
 						log.Fatal(err)
 					}
@@ -62,7 +67,7 @@ func main() {
 				Name:  "ls",
 				Usage: "List our existing sessions.",
 				Action: func(c *cli.Context) error {
-					client, err := userup.NewClient("localhost:9000")
+					client, err := userup.NewClient("localhost:9000", userup.WithDefaultTimeout(callTimeout))
 					if err != nil {
 						log.Fatal(err)
 					}
@@ -100,7 +105,7 @@ func main() {
 				Name:  "get",
 				Usage: "Get a specific session's events.",
 				Action: func(c *cli.Context) error {
-					client, err := userup.NewClient("localhost:9000")
+					client, err := userup.NewClient("localhost:9000", userup.WithDefaultTimeout(callTimeout))
 					if err != nil {
 						log.Fatal(err)
 					}
@@ -149,7 +154,7 @@ func main() {
 					},
 				},
 				Action: func(c *cli.Context) error {
-					client, err := userup.NewClient("localhost:9000")
+					client, err := userup.NewClient("localhost:9000", userup.WithDefaultTimeout(callTimeout))
 					if err != nil {
 						log.Fatal(err)
 					}
@@ -200,10 +205,11 @@ func main() {
 				},
 			},
 			{
-				Name:  "identify",
-				Usage: "Identify a session as belonging to a specific user.",
+				Name:      "identify",
+				Usage:     "Identify a session as belonging to a specific user.",
+				ArgsUsage: "SESSION_ID USER_ID (42, id:42, uuid:<uuid> or ext:<external id>)",
 				Action: func(c *cli.Context) error {
-					client, err := userup.NewClient("localhost:9000")
+					client, err := userup.NewClient("localhost:9000", userup.WithDefaultTimeout(callTimeout))
 					if err != nil {
 						log.Fatal(err)
 					}
@@ -216,12 +222,15 @@ func main() {
 						return fmt.Errorf("Missing args: SESSION_ID USER_ID")
 					}
 
-					userID, err := strconv.ParseUint(userIDstr, 10, 64)
-					if err != nil {
+					// Plain numbers are still accepted as numeric IDs.
+					var userID userup.UserID
+					if n, err := strconv.ParseUint(userIDstr, 10, 64); err == nil {
+						userID = userup.UID(n)
+					} else if userID, err = userup.ParseUserID(userIDstr); err != nil {
 						return err
 					}
 
-					return client.IdentifySession(context.Background(), sessID, userup.UID(userID))
+					return client.IdentifySession(context.Background(), sessID, userID)
 				},
 			},
 		},
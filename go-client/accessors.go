@@ -0,0 +1,46 @@
+package userup
+
+import (
+	"reflect"
+)
+
+// Attr returns the attribute key of u converted to T. The boolean reports
+// whether the attribute is set; the error reports a value that cannot be
+// converted to T.
+//
+// Values read back from the server are JSON-like, so Attr converts them the
+// way UnmarshalUser does: numbers to any numeric kind they fit in, RFC 3339
+// strings to time.Time, and nested maps to structs, slices and maps.
+//
+//	ranking, ok, err := userup.Attr[int](user, "ranking")
+func Attr[T any](u *User, key string) (T, bool, error) {
+	if u == nil {
+		var zero T
+		return zero, false, nil
+	}
+	return lookupAs[T](u.Attributes, "attributes."+key, key)
+}
+
+// Trait returns the trait key of u converted to T, following the same rules
+// as Attr.
+//
+//	plan, ok, err := userup.Trait[string](user, "plan")
+func Trait[T any](u *User, key string) (T, bool, error) {
+	if u == nil {
+		var zero T
+		return zero, false, nil
+	}
+	return lookupAs[T](u.Traits, "traits."+key, key)
+}
+
+func lookupAs[T any](values map[string]interface{}, path string, key string) (T, bool, error) {
+	var out T
+	v, ok := values[key]
+	if !ok {
+		return out, false, nil
+	}
+	if err := decodeValue(path, v, reflect.ValueOf(&out).Elem()); err != nil {
+		return out, true, err
+	}
+	return out, true, nil
+}
@@ -0,0 +1,331 @@
+package userup
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/health" // enables client-side health checking
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
+)
+
+// balancerName is the name the SDK's load balancer is registered under.
+const balancerName = "userup_round_robin"
+
+// endpointScheme is the resolver scheme used for comma separated address
+// lists passed to NewClient.
+const endpointScheme = "userup"
+
+func init() {
+	balancer.Register(ejectingBuilder{})
+}
+
+// OutlierEjection configures how failing endpoints are taken out of the
+// round robin rotation. Zero fields use the defaults noted below.
+type OutlierEjection struct {
+	ConsecutiveFailures int           `json:"consecutiveFailures"` // Failed calls in a row that eject an endpoint. Default 5.
+	BaseEjectionTime    time.Duration `json:"baseEjectionTime"`    // Time out of rotation for a first ejection; doubles on each repeat. Default 30s.
+	MaxEjectionTime     time.Duration `json:"maxEjectionTime"`     // Upper bound on the time out of rotation. Default 5m.
+	MaxEjectionPercent  int           `json:"maxEjectionPercent"`  // Largest share of endpoints ejected at once. Default 50.
+}
+
+func (oe OutlierEjection) withDefaults() OutlierEjection {
+	if oe.ConsecutiveFailures <= 0 {
+		oe.ConsecutiveFailures = 5
+	}
+	if oe.BaseEjectionTime <= 0 {
+		oe.BaseEjectionTime = 30 * time.Second
+	}
+	if oe.MaxEjectionTime <= 0 {
+		oe.MaxEjectionTime = 5 * time.Minute
+	}
+	if oe.MaxEjectionPercent <= 0 || oe.MaxEjectionPercent > 100 {
+		oe.MaxEjectionPercent = 50
+	}
+	return oe
+}
+
+// WithLoadBalancing spreads calls over every address the target resolves to
+// in round robin order, ejecting endpoints that keep failing. It is enabled
+// automatically, with default settings, for address lists and dns:/// targets.
+// When WithHealthService is also given, endpoints that report NOT_SERVING
+// are left out of the rotation as well.
+func WithLoadBalancing(ejection OutlierEjection) ClientOption {
+	return func(o *clientOptions) {
+		o.loadBalancing = &ejection
+	}
+}
+
+// resolveTarget turns the URI given to NewClient into a gRPC target and the
+// dial options needed to reach it. A comma separated list of addresses is
+// served by a static resolver.
+func (o clientOptions) resolveTarget(uri string) (string, []grpc.DialOption, error) {
+	var dialOpts []grpc.DialOption
+	target := uri
+	balancing := o.loadBalancing
+
+	if strings.Contains(uri, ",") {
+		var addrs []resolver.Address
+		for _, addr := range strings.Split(uri, ",") {
+			addr = strings.TrimSpace(addr)
+			if addr != "" {
+				addrs = append(addrs, resolver.Address{Addr: addr, ServerName: hostOf(addr)})
+			}
+		}
+		r := manual.NewBuilderWithScheme(endpointScheme)
+		r.InitialState(resolver.State{Addresses: addrs})
+		dialOpts = append(dialOpts, grpc.WithResolvers(r))
+		target = endpointScheme + ":///" + uri
+		if balancing == nil {
+			balancing = &OutlierEjection{}
+		}
+	} else if strings.HasPrefix(uri, "dns:") && balancing == nil {
+		balancing = &OutlierEjection{}
+	}
+
+	if balancing != nil {
+		sc := map[string]interface{}{
+			"loadBalancingConfig": []interface{}{
+				map[string]interface{}{balancerName: balancing.withDefaults()},
+			},
+		}
+		if o.healthCheck {
+			sc["healthCheckConfig"] = map[string]interface{}{
+				"serviceName": o.healthService,
+			}
+		}
+		cfg, err := json.Marshal(sc)
+		if err != nil {
+			return "", nil, err
+		}
+		dialOpts = append(dialOpts, grpc.WithDefaultServiceConfig(string(cfg)))
+	}
+
+	return target, dialOpts, nil
+}
+
+// hostOf returns the host part of addr. Each address of a list is verified
+// against its own host when TLS is used; without it the whole list would be
+// taken as the server name.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// ejectionConfig is the parsed load balancing config of the balancer.
+type ejectionConfig struct {
+	serviceconfig.LoadBalancingConfig
+	OutlierEjection
+}
+
+// ejectingBuilder builds round robin balancers with outlier ejection on top
+// of the base balancer, which also applies client-side health checking.
+type ejectingBuilder struct{}
+
+func (ejectingBuilder) Name() string {
+	return balancerName
+}
+
+func (ejectingBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	state := &ejectionState{
+		config:    OutlierEjection{}.withDefaults(),
+		endpoints: make(map[string]*endpointStats),
+	}
+	b := base.NewBalancerBuilder(balancerName, &ejectingPickerBuilder{state: state}, base.Config{HealthCheck: true})
+	return &ejectingBalancer{
+		Balancer: b.Build(cc, opts),
+		state:    state,
+	}
+}
+
+func (ejectingBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	var cfg ejectionConfig
+	if err := json.Unmarshal(js, &cfg.OutlierEjection); err != nil {
+		return nil, fmt.Errorf("userup: invalid %s config: %w", balancerName, err)
+	}
+	cfg.OutlierEjection = cfg.OutlierEjection.withDefaults()
+	return &cfg, nil
+}
+
+// ejectingBalancer passes the parsed config on to the shared ejection state.
+type ejectingBalancer struct {
+	balancer.Balancer
+	state *ejectionState
+}
+
+func (b *ejectingBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if cfg, ok := s.BalancerConfig.(*ejectionConfig); ok {
+		b.state.setConfig(cfg.OutlierEjection)
+	}
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+func (b *ejectingBalancer) ExitIdle() {
+	if ei, ok := b.Balancer.(balancer.ExitIdler); ok {
+		ei.ExitIdle()
+	}
+}
+
+// ejectingPickerBuilder builds pickers over the ready endpoints.
+type ejectingPickerBuilder struct {
+	state *ejectionState
+}
+
+func (pb *ejectingPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	p := &ejectingPicker{state: pb.state}
+	for sc, sci := range info.ReadySCs {
+		p.subConns = append(p.subConns, sc)
+		p.addrs = append(p.addrs, sci.Address.Addr)
+	}
+	pb.state.setEndpoints(p.addrs)
+	return p
+}
+
+// ejectingPicker picks endpoints in turn, skipping ejected ones.
+type ejectingPicker struct {
+	subConns []balancer.SubConn
+	addrs    []string
+	state    *ejectionState
+	next     atomic.Uint32
+}
+
+func (p *ejectingPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	n := uint32(len(p.subConns))
+	start := p.next.Add(1)
+	now := time.Now()
+	for i := uint32(0); i < n; i++ {
+		idx := (start + i) % n
+		if !p.state.ejected(p.addrs[idx], now) {
+			return p.result(idx), nil
+		}
+	}
+	// Every endpoint is ejected. Keep serving rather than failing every call.
+	return p.result(start % n), nil
+}
+
+func (p *ejectingPicker) result(idx uint32) balancer.PickResult {
+	addr := p.addrs[idx]
+	return balancer.PickResult{
+		SubConn: p.subConns[idx],
+		Done: func(info balancer.DoneInfo) {
+			p.state.record(addr, info.Err)
+		},
+	}
+}
+
+// endpointStats tracks the recent outcomes of calls to one endpoint.
+type endpointStats struct {
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// ejectionState is shared by a balancer and the pickers it builds.
+type ejectionState struct {
+	mu        sync.Mutex
+	config    OutlierEjection
+	endpoints map[string]*endpointStats
+}
+
+func (s *ejectionState) setConfig(cfg OutlierEjection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = cfg
+}
+
+// setEndpoints forgets endpoints that are no longer ready and starts
+// tracking new ones.
+func (s *ejectionState) setEndpoints(addrs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := make(map[string]*endpointStats, len(addrs))
+	for _, addr := range addrs {
+		if st, ok := s.endpoints[addr]; ok {
+			current[addr] = st
+		} else {
+			current[addr] = &endpointStats{}
+		}
+	}
+	s.endpoints = current
+}
+
+func (s *ejectionState) ejected(addr string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.endpoints[addr]
+	return ok && now.Before(st.ejectedUntil)
+}
+
+// record updates the stats of addr with the outcome of a call and ejects it
+// once it has failed too many times in a row.
+func (s *ejectionState) record(addr string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.endpoints[addr]
+	if !ok {
+		return
+	}
+	if !endpointFailure(err) {
+		st.failures = 0
+		return
+	}
+
+	st.failures++
+	now := time.Now()
+	if st.failures < s.config.ConsecutiveFailures || now.Before(st.ejectedUntil) {
+		return
+	}
+
+	ejected := 0
+	for _, other := range s.endpoints {
+		if now.Before(other.ejectedUntil) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > len(s.endpoints)*s.config.MaxEjectionPercent {
+		return
+	}
+
+	st.ejections++
+	d := s.config.BaseEjectionTime << (st.ejections - 1)
+	if d <= 0 || d > s.config.MaxEjectionTime {
+		d = s.config.MaxEjectionTime
+	}
+	st.ejectedUntil = now.Add(d)
+	st.failures = 0
+}
+
+// endpointFailure reports whether err points at a problem with the endpoint
+// rather than with the request. DeadlineExceeded is not counted: deadlines
+// are usually set by the caller, and a short one would otherwise eject
+// healthy endpoints.
+func endpointFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal:
+		return true
+	}
+	return false
+}
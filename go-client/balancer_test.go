@@ -0,0 +1,149 @@
+package userup
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// flakyServer counts the Get calls it serves and fails them with Internal
+// while failing is set.
+type flakyServer struct {
+	userapi.UnimplementedUsersServer
+	calls   atomic.Int32
+	failing atomic.Bool
+}
+
+func (s *flakyServer) Get(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	s.calls.Add(1)
+	if s.failing.Load() {
+		return nil, status.Error(codes.Internal, "broken")
+	}
+	return &userapi.UserResponse{Id: req.Id}, nil
+}
+
+func TestBalancerEjectsAndRestoresEndpoint(t *testing.T) {
+	a, b := &flakyServer{}, &flakyServer{}
+	addrA, addrB := listenTestServer(t, a), listenTestServer(t, b)
+
+	const ejectionTime = 300 * time.Millisecond
+	client := newTestClient(t, addrA+","+addrB, WithLoadBalancing(OutlierEjection{
+		ConsecutiveFailures: 2,
+		BaseEjectionTime:    ejectionTime,
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Wait until both endpoints are in the rotation.
+	for a.calls.Load() == 0 || b.calls.Load() == 0 {
+		if _, err := client.GetUser(ctx, UID(1)); err != nil {
+			t.Fatalf("GetUser: %v", err)
+		}
+		if ctx.Err() != nil {
+			t.Fatal("both endpoints never received calls")
+		}
+	}
+
+	b.failing.Store(true)
+	a.calls.Store(0)
+	b.calls.Store(0)
+	failed := 0
+	for i := 0; i < 20; i++ {
+		if _, err := client.GetUser(ctx, UID(1)); err != nil {
+			failed++
+		}
+	}
+	if got := b.calls.Load(); got != 2 {
+		t.Errorf("failing endpoint got %d calls, want 2 before it is ejected", got)
+	}
+	if failed != 2 {
+		t.Errorf("%d calls failed, want 2", failed)
+	}
+	if got := a.calls.Load(); got != 18 {
+		t.Errorf("healthy endpoint got %d calls, want 18", got)
+	}
+
+	b.failing.Store(false)
+	time.Sleep(ejectionTime + 50*time.Millisecond)
+	b.calls.Store(0)
+	for i := 0; i < 10; i++ {
+		if _, err := client.GetUser(ctx, UID(1)); err != nil {
+			t.Fatalf("GetUser after recovery: %v", err)
+		}
+	}
+	if got := b.calls.Load(); got != 5 {
+		t.Errorf("recovered endpoint got %d of 10 calls, want 5", got)
+	}
+}
+
+func TestEndpointFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{status.Error(codes.Unavailable, ""), true},
+		{status.Error(codes.Internal, ""), true},
+		{status.Error(codes.DeadlineExceeded, ""), false},
+		{status.Error(codes.NotFound, ""), false},
+		{status.Error(codes.InvalidArgument, ""), false},
+		{errors.New("plain error"), false},
+	}
+	for _, tt := range tests {
+		if got := endpointFailure(tt.err); got != tt.want {
+			t.Errorf("endpointFailure(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestEjectionRespectsMaxPercent(t *testing.T) {
+	s := &ejectionState{
+		config:    OutlierEjection{ConsecutiveFailures: 1, MaxEjectionPercent: 50}.withDefaults(),
+		endpoints: make(map[string]*endpointStats),
+	}
+	s.setEndpoints([]string{"a", "b"})
+	unavailable := status.Error(codes.Unavailable, "")
+
+	s.record("a", unavailable)
+	s.record("b", unavailable)
+	now := time.Now()
+	if !s.ejected("a", now) {
+		t.Error("a was not ejected")
+	}
+	if s.ejected("b", now) {
+		t.Error("b was ejected although that leaves no endpoint in rotation")
+	}
+}
+
+func TestBalancerOverTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverConf := ca.serverTLS(t)
+	serverConf.ClientAuth = tls.NoClientCert
+	creds := grpc.Creds(credentials.NewTLS(serverConf))
+	a, b := &flakyServer{}, &flakyServer{}
+	addrA, addrB := listenTestServer(t, a, creds), listenTestServer(t, b, creds)
+
+	// No ServerName: each endpoint is verified against its own host.
+	cfg := TLSConfig{CAFile: filepath.Join(t.TempDir(), "ca.pem")}
+	writeFile(t, cfg.CAFile, ca.pem)
+	client := newTestClient(t, addrA+","+addrB, WithTLS(cfg))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for a.calls.Load() == 0 || b.calls.Load() == 0 {
+		if _, err := client.GetUser(ctx, UID(1)); err != nil {
+			t.Fatalf("GetUser: %v", err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package userup
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultBatchConcurrency is the number of calls a batch operation runs at
+// once when BatchConcurrency is not given.
+const DefaultBatchConcurrency = 8
+
+// BatchOption configures a batch operation such as AddUsers.
+type BatchOption func(*batchOptions)
+
+type batchOptions struct {
+	concurrency int
+	progress    func(done, total int)
+}
+
+// BatchConcurrency limits the number of calls a batch operation runs at once.
+func BatchConcurrency(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.concurrency = n
+	}
+}
+
+// BatchProgress registers fn to be called each time an input has been
+// processed, with the number processed so far and the total. Calls to fn are
+// never made concurrently.
+func BatchProgress(fn func(done, total int)) BatchOption {
+	return func(o *batchOptions) {
+		o.progress = fn
+	}
+}
+
+// BatchResult is the outcome of a batch operation for one input.
+type BatchResult struct {
+	Index int   // Position of the input in the slice passed to the batch operation.
+	User  *User // User returned by the server, for AddUsers and UpdateUsers.
+	Err   error // Error of the call, or of the context if the input was never sent.
+}
+
+// BatchResults holds one BatchResult per input, in input order.
+type BatchResults []BatchResult
+
+// Failed returns the results whose call failed. The Index of each tells
+// which input to retry.
+func (r BatchResults) Failed() BatchResults {
+	var failed BatchResults
+	for _, res := range r {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// AttributeUpdate is one attribute to set with SetAttributes.
+type AttributeUpdate struct {
+	UserID UserID
+	Key    string
+	Value  interface{}
+}
+
+// AddUsers adds users with AddUser, running several calls at once. Once ctx
+// is done no new calls are started and the remaining inputs fail with the
+// context's error.
+func (us UserService) AddUsers(ctx context.Context, users []*User, opts ...BatchOption) BatchResults {
+	return runBatch(ctx, len(users), opts, func(ctx context.Context, i int) (*User, error) {
+		return us.AddUser(ctx, users[i])
+	})
+}
+
+// UpdateUsers updates users with UpdateUser, running several calls at once.
+// See AddUsers.
+func (us UserService) UpdateUsers(ctx context.Context, users []*User, opts ...BatchOption) BatchResults {
+	return runBatch(ctx, len(users), opts, func(ctx context.Context, i int) (*User, error) {
+		return us.UpdateUser(ctx, users[i])
+	})
+}
+
+// DeleteUsers deletes users with DeleteUser, running several calls at once.
+// See AddUsers.
+func (us UserService) DeleteUsers(ctx context.Context, ids []UserID, opts ...BatchOption) BatchResults {
+	return runBatch(ctx, len(ids), opts, func(ctx context.Context, i int) (*User, error) {
+		return nil, us.DeleteUser(ctx, ids[i])
+	})
+}
+
+// SetAttributes sets attributes with AddAttribute, running several calls at
+// once. See AddUsers.
+func (us UserService) SetAttributes(ctx context.Context, updates []AttributeUpdate, opts ...BatchOption) BatchResults {
+	return runBatch(ctx, len(updates), opts, func(ctx context.Context, i int) (*User, error) {
+		u := updates[i]
+		return nil, us.AddAttribute(ctx, u.UserID, u.Key, u.Value)
+	})
+}
+
+// runBatch calls fn for each of n inputs with at most the configured number
+// of calls in flight.
+func runBatch(ctx context.Context, n int, opts []BatchOption, fn func(ctx context.Context, i int) (*User, error)) BatchResults {
+	o := batchOptions{concurrency: DefaultBatchConcurrency}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.concurrency < 1 {
+		o.concurrency = 1
+	}
+
+	results := make(BatchResults, n)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		done int
+	)
+	finish := func(i int, user *User, err error) {
+		results[i] = BatchResult{Index: i, User: user, Err: err}
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		if o.progress != nil {
+			o.progress(done, n)
+		}
+	}
+
+	sem := make(chan struct{}, o.concurrency)
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			finish(i, nil, err)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			finish(i, nil, ctx.Err())
+			continue
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			user, err := fn(ctx, i)
+			finish(i, user, err)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
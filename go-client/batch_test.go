@@ -0,0 +1,161 @@
+package userup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// batchServer echoes created users after a short delay, tracking how many
+// calls run at once. With started set, Create reports each call on it and
+// blocks until the call's context is done. Delete fails for IDs in missing.
+type batchServer struct {
+	userapi.UnimplementedUsersServer
+	calls, inFlight, maxInFlight atomic.Int32
+	started                      chan struct{}
+	missing                      map[uint64]bool
+}
+
+func (s *batchServer) Create(ctx context.Context, req *userapi.NewUser) (*userapi.UserResponse, error) {
+	n := s.calls.Add(1)
+	cur := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		max := s.maxInFlight.Load()
+		if cur <= max || s.maxInFlight.CompareAndSwap(max, cur) {
+			break
+		}
+	}
+
+	if s.started != nil {
+		s.started <- struct{}{}
+		<-ctx.Done()
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	// Vary the delay so calls finish out of input order.
+	time.Sleep(time.Duration(10+n%3*5) * time.Millisecond)
+	return &userapi.UserResponse{Id: &userapi.UserID{Id: uint64(n)}, Username: req.Username}, nil
+}
+
+func (s *batchServer) Delete(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	if s.missing[req.Id.GetId()] {
+		return nil, status.Error(codes.NotFound, "no such user")
+	}
+	return &userapi.UserResponse{Id: req.Id}, nil
+}
+
+func newBatchUsers(n int) []*User {
+	users := make([]*User, n)
+	for i := range users {
+		users[i] = &User{Username: fmt.Sprintf("user-%d", i)}
+	}
+	return users
+}
+
+func TestAddUsers(t *testing.T) {
+	tests := []struct {
+		opts    []BatchOption
+		wantMax int32
+	}{
+		{[]BatchOption{BatchConcurrency(3)}, 3},
+		{[]BatchOption{BatchConcurrency(0)}, 1},
+		{nil, DefaultBatchConcurrency},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.wantMax), func(t *testing.T) {
+			srv := &batchServer{}
+			client := startTestServer(t, srv)
+			users := newBatchUsers(4 * DefaultBatchConcurrency)
+
+			var inProgress atomic.Int32
+			var progress []int
+			opts := append(tt.opts, BatchProgress(func(done, total int) {
+				if inProgress.Add(1) != 1 {
+					t.Error("progress called concurrently")
+				}
+				defer inProgress.Add(-1)
+				if total != len(users) {
+					t.Errorf("progress total = %d, want %d", total, len(users))
+				}
+				progress = append(progress, done)
+			}))
+			results := client.AddUsers(context.Background(), users, opts...)
+
+			if got := srv.maxInFlight.Load(); got != tt.wantMax {
+				t.Errorf("%d calls ran at once, want %d", got, tt.wantMax)
+			}
+			if len(results) != len(users) {
+				t.Fatalf("got %d results, want %d", len(results), len(users))
+			}
+			for i, res := range results {
+				if res.Err != nil {
+					t.Fatalf("result %d: %v", i, res.Err)
+				}
+				if res.Index != i || res.User.Username != users[i].Username {
+					t.Errorf("result %d has index %d and user %q, want %d and %q", i, res.Index, res.User.Username, i, users[i].Username)
+				}
+			}
+			if len(progress) != len(users) {
+				t.Fatalf("progress called %d times, want %d", len(progress), len(users))
+			}
+			for i, done := range progress {
+				if done != i+1 {
+					t.Fatalf("progress reported %v, want 1 to %d in order", progress, len(users))
+				}
+			}
+		})
+	}
+}
+
+func TestAddUsersCancelled(t *testing.T) {
+	srv := &batchServer{started: make(chan struct{})}
+	client := startTestServer(t, srv, WithRetry(RetryPolicy{MaxAttempts: 1}))
+	users := newBatchUsers(6)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-srv.started
+		<-srv.started
+		cancel()
+	}()
+	results := client.AddUsers(ctx, users, BatchConcurrency(2))
+
+	if got := srv.calls.Load(); got != 2 {
+		t.Errorf("server got %d calls, want 2", got)
+	}
+	for i, res := range results {
+		if !errors.Is(res.Err, context.Canceled) {
+			t.Errorf("result %d: error %v, want context.Canceled", i, res.Err)
+		}
+		if i >= 2 && res.Err != context.Canceled {
+			t.Errorf("unsent input %d failed with %v, want the context's error", i, res.Err)
+		}
+	}
+	if got := len(results.Failed()); got != len(users) {
+		t.Errorf("%d results failed, want all %d", got, len(users))
+	}
+}
+
+func TestDeleteUsersFailed(t *testing.T) {
+	srv := &batchServer{missing: map[uint64]bool{2: true, 4: true}}
+	client := startTestServer(t, srv)
+
+	ids := []UserID{UID(1), UID(2), UID(3), UID(4)}
+	failed := client.DeleteUsers(context.Background(), ids).Failed()
+	if len(failed) != 2 || failed[0].Index != 1 || failed[1].Index != 3 {
+		t.Fatalf("Failed() = %+v, want inputs 1 and 3", failed)
+	}
+	for _, res := range failed {
+		if !errors.Is(res.Err, ErrNotFound) {
+			t.Errorf("input %d failed with %v, want ErrNotFound", res.Index, res.Err)
+		}
+	}
+}
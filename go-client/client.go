@@ -0,0 +1,87 @@
+package userup
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// Client is a single connection to the user service shared by the Users and
+// Integrations services. Use it instead of NewClient and NewIntegrationsClient
+// when both services live at the same address.
+type Client struct {
+	addr         string
+	conn         *grpc.ClientConn
+	users        *UserService
+	integrations *IntegrationsService
+}
+
+// Dial creates a new Client connected to the specified URI.
+// The URI should be in the format "host:port", a comma separated list of
+// such addresses, or a gRPC target such as "dns:///host:port".
+// Options can be supplied to tune the underlying connection.
+// If the connection cannot be established, an error is returned.
+func Dial(uri string, opts ...ClientOption) (*Client, error) {
+	o := newClientOptions(opts)
+	conn, err := dial(uri, o)
+	if err != nil {
+		return nil, err
+	}
+
+	// The services share the Client's connection, so closing one of them
+	// must not tear it down for the other.
+	noClose := func() error { return nil }
+
+	return &Client{
+		addr: uri,
+		conn: conn,
+		users: &UserService{
+			addr:   uri,
+			conn:   conn,
+			client: userapi.NewUsersClient(conn),
+			close:  noClose,
+			opts:   o,
+		},
+		integrations: &IntegrationsService{
+			addr:   uri,
+			client: userapi.NewIntegrationsClient(conn),
+			close:  noClose,
+		},
+	}, nil
+}
+
+// Users returns the UserService backed by the Client's connection.
+// Calling Close on it has no effect; close the Client instead.
+func (c *Client) Users() *UserService {
+	return c.users
+}
+
+// Integrations returns the IntegrationsService backed by the Client's
+// connection. Calling Close on it has no effect; close the Client instead.
+func (c *Client) Integrations() *IntegrationsService {
+	return c.integrations
+}
+
+// NewLogger creates an EventLogger that logs events with the given source
+// through the Client's UserService.
+func (c *Client) NewLogger(source string) EventLogger {
+	return NewLogger(NewLoggerConfig(source, c.users))
+}
+
+// Ping checks that the user service is serving. See UserService.Ping.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.users.Ping(ctx)
+}
+
+// WaitReady blocks until the shared connection is ready. See
+// UserService.WaitReady.
+func (c *Client) WaitReady(ctx context.Context) error {
+	return c.users.WaitReady(ctx)
+}
+
+// Close closes the connection shared by the Client's services.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
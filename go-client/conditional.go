@@ -0,0 +1,142 @@
+package userup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// DefaultConflictRetryPolicy is the policy ModifyUser uses when
+// WithConflictRetry is not given.
+var DefaultConflictRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	Jitter:         0.5,
+}
+
+// WithConflictRetry sets how often, and with what backoff, ModifyUser starts
+// over when it finds the user was changed concurrently. Only MaxAttempts and
+// the backoff fields of policy are used.
+func WithConflictRetry(policy RetryPolicy) ClientOption {
+	return func(o *clientOptions) {
+		o.conflictRetry = &policy
+	}
+}
+
+// UpdateAttributeIf sets the attribute key of the user to newValue, but only
+// if its current value is expected. A nil expected value matches an attribute
+// that is not set. Values are compared as they are stored, so an int 5
+// matches a float64 5. If the attribute holds something else,
+// UpdateAttributeIf returns an error matching ErrConflict and changes
+// nothing.
+//
+// The user service has no conditional write, so the check and the write are
+// separate calls. This narrows the window for lost updates between workers
+// but cannot close it.
+func (us UserService) UpdateAttributeIf(ctx context.Context, id UserID, key string, expected, newValue interface{}) error {
+	user, err := us.GetUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	current := user.Attributes[key]
+	if !equalValues(current, expected) {
+		return conflict(userapi.Users_AddAttribute_FullMethodName,
+			fmt.Errorf("attribute %q is %v, expected %v", key, current, expected))
+	}
+	return us.AddAttribute(ctx, id, key, newValue)
+}
+
+// ModifyUser reads the user, passes it to fn to change, and writes back the
+// changes fn made with PatchUser. Just before writing it reads the user
+// again; if it changed in the meantime, ModifyUser waits and starts over, so
+// fn must be safe to call more than once. It gives up with an error matching
+// ErrConflict after the number of attempts set by WithConflictRetry.
+//
+// An error returned by fn stops ModifyUser and is returned unchanged. On
+// success ModifyUser returns the user as fn left it.
+//
+// As with UpdateAttributeIf, the final read and the write are separate calls,
+// so a change made by someone else between them is still overwritten. The
+// re-read narrows the window for lost updates but cannot close it.
+func (us UserService) ModifyUser(ctx context.Context, id UserID, fn func(*User) error) (*User, error) {
+	policy := DefaultConflictRetryPolicy
+	if us.opts.conflictRetry != nil {
+		policy = *us.opts.conflictRetry
+	}
+	maxAttempts := max(policy.MaxAttempts, 1)
+
+	for attempt := 1; ; attempt++ {
+		user, err := us.GetUser(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		before := cloneUser(user)
+		if err := fn(user); err != nil {
+			return nil, err
+		}
+		patch := Diff(before, user)
+		if len(patch) == 0 {
+			return user, nil
+		}
+
+		current, err := us.GetUser(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if current.Username == before.Username && len(Diff(before, current)) == 0 {
+			if err := us.PatchUser(ctx, id, patch); err != nil {
+				return nil, err
+			}
+			return user, nil
+		}
+
+		if attempt >= maxAttempts {
+			return nil, conflict(userapi.Users_Update_FullMethodName,
+				fmt.Errorf("user changed concurrently, gave up after %d attempts", attempt))
+		}
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// cloneUser returns a deep copy of u, so changes made through one do not show
+// in the other.
+func cloneUser(u *User) *User {
+	c := *u
+	c.Attributes, _ = cloneValue(u.Attributes).(map[string]interface{})
+	c.Traits, _ = cloneValue(u.Traits).(map[string]interface{})
+	return &c
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		c := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			c[k] = cloneValue(item)
+		}
+		return c
+	case []interface{}:
+		if v == nil {
+			return v
+		}
+		c := make([]interface{}, len(v))
+		for i, item := range v {
+			c[i] = cloneValue(item)
+		}
+		return c
+	}
+	return v
+}
@@ -0,0 +1,158 @@
+package userup
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+func TestUpdateAttributeIf(t *testing.T) {
+	tests := []struct {
+		name         string
+		current      map[string]interface{}
+		expected     interface{}
+		wantConflict bool
+	}{
+		{"matching value", map[string]interface{}{"plan": "free"}, "free", false},
+		{"int matches stored float", map[string]interface{}{"plan": 5.0}, 5, false},
+		{"nil matches unset", nil, nil, false},
+		{"different value", map[string]interface{}{"plan": "team"}, "free", true},
+		{"nil does not match a set value", map[string]interface{}{"plan": "free"}, nil, true},
+		{"value does not match unset", nil, "free", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &patchServer{username: "alice", attributes: tt.current}
+			client := startTestServer(t, srv)
+
+			err := client.UpdateAttributeIf(context.Background(), UID(1), "plan", tt.expected, "pro")
+			want := "pro"
+			if tt.wantConflict {
+				if !errors.Is(err, ErrConflict) {
+					t.Fatalf("UpdateAttributeIf error = %v, want ErrConflict", err)
+				}
+				var e *Error
+				if errors.As(err, &e) && e.Method != userapi.Users_AddAttribute_FullMethodName {
+					t.Errorf("Method = %q, want %q", e.Method, userapi.Users_AddAttribute_FullMethodName)
+				}
+				want, _ = tt.current["plan"].(string)
+			} else if err != nil {
+				t.Fatalf("UpdateAttributeIf: %v", err)
+			}
+			if got, _ := srv.attributes["plan"].(string); got != want {
+				t.Errorf("plan = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// conflictRetry retries conflicts quickly so tests do not wait on backoff.
+var conflictRetry = WithConflictRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: 1, MaxBackoff: 1})
+
+// setPlan returns a ModifyUser callback that sets the plan attribute and
+// counts its calls.
+func setPlan(calls *int) func(*User) error {
+	return func(u *User) error {
+		*calls++
+		u.Attributes["plan"] = "pro"
+		return nil
+	}
+}
+
+func TestModifyUser(t *testing.T) {
+	srv := &patchServer{username: "alice", attributes: map[string]interface{}{"plan": "free"}}
+	client := startTestServer(t, srv, conflictRetry)
+
+	calls := 0
+	user, err := client.ModifyUser(context.Background(), UID(1), setPlan(&calls))
+	if err != nil {
+		t.Fatalf("ModifyUser: %v", err)
+	}
+	// The read, the re-read, and PatchUser's read of the username.
+	if calls != 1 || srv.gets != 3 || len(srv.updates) != 1 {
+		t.Errorf("got %d calls of fn, %d reads and %d updates, want 1, 3 and 1", calls, srv.gets, len(srv.updates))
+	}
+	if user.Attributes["plan"] != "pro" || srv.attributes["plan"] != "pro" {
+		t.Errorf("plan is %v on the returned user and %v on the server, want pro", user.Attributes["plan"], srv.attributes["plan"])
+	}
+	if got := srv.updates[0].Username; got != "alice" {
+		t.Errorf("update sent username %q, want alice", got)
+	}
+}
+
+func TestModifyUserNoChange(t *testing.T) {
+	srv := &patchServer{username: "alice", attributes: map[string]interface{}{"plan": "pro"}}
+	client := startTestServer(t, srv, conflictRetry)
+
+	calls := 0
+	if _, err := client.ModifyUser(context.Background(), UID(1), setPlan(&calls)); err != nil {
+		t.Fatalf("ModifyUser: %v", err)
+	}
+	if calls != 1 || srv.gets != 1 || len(srv.updates) != 0 {
+		t.Errorf("got %d calls of fn, %d reads and %d updates, want 1, 1 and 0", calls, srv.gets, len(srv.updates))
+	}
+}
+
+func TestModifyUserRetriesAfterConcurrentChange(t *testing.T) {
+	srv := &patchServer{username: "alice", attributes: map[string]interface{}{"plan": "free"}}
+	// Someone else sets a seat count between the first read and the re-read.
+	srv.onGet = func(s *patchServer) {
+		if s.gets == 2 {
+			s.attributes["seats"] = 5.0
+		}
+	}
+	client := startTestServer(t, srv, conflictRetry)
+
+	calls := 0
+	var seen []interface{}
+	_, err := client.ModifyUser(context.Background(), UID(1), func(u *User) error {
+		seen = append(seen, u.Attributes["seats"])
+		return setPlan(&calls)(u)
+	})
+	if err != nil {
+		t.Fatalf("ModifyUser: %v", err)
+	}
+	if calls != 2 || len(srv.updates) != 1 {
+		t.Fatalf("got %d calls of fn and %d updates, want 2 and 1", calls, len(srv.updates))
+	}
+	if seen[1] != 5.0 {
+		t.Errorf("second call of fn saw seats %v, want the concurrent 5", seen[1])
+	}
+	if srv.attributes["plan"] != "pro" || srv.attributes["seats"] != 5.0 {
+		t.Errorf("server attributes = %v, want plan pro and seats 5", srv.attributes)
+	}
+}
+
+func TestModifyUserGivesUpOnConflict(t *testing.T) {
+	srv := &patchServer{username: "alice", attributes: map[string]interface{}{"plan": "free"}}
+	// Every read sees a new rename, so the re-read never matches.
+	srv.onGet = func(s *patchServer) {
+		s.username += "!"
+	}
+	client := startTestServer(t, srv, conflictRetry)
+
+	calls := 0
+	_, err := client.ModifyUser(context.Background(), UID(1), setPlan(&calls))
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("ModifyUser error = %v, want ErrConflict", err)
+	}
+	if calls != 3 || srv.gets != 6 || len(srv.updates) != 0 {
+		t.Errorf("got %d calls of fn, %d reads and %d updates, want 3, 6 and 0", calls, srv.gets, len(srv.updates))
+	}
+}
+
+func TestModifyUserStopsOnCallbackError(t *testing.T) {
+	srv := &patchServer{username: "alice"}
+	client := startTestServer(t, srv, conflictRetry)
+
+	errStop := errors.New("stop")
+	_, err := client.ModifyUser(context.Background(), UID(1), func(*User) error { return errStop })
+	if err != errStop {
+		t.Fatalf("ModifyUser error = %v, want the callback's error unchanged", err)
+	}
+	if len(srv.updates) != 0 {
+		t.Errorf("server got %d updates, want none", len(srv.updates))
+	}
+}
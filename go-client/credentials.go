@@ -0,0 +1,176 @@
+package userup
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// APIKeyHeader is the metadata key APIKey credentials are sent in.
+const APIKeyHeader = "x-api-key"
+
+// defaultRefreshBefore is how long before expiry a cached token is refreshed
+// when no other value is given to NewRefreshingCredentials.
+const defaultRefreshBefore = time.Minute
+
+// WithCredentials attaches per-RPC credentials, such as an API key or a
+// bearer token, to every call made by the client.
+//
+// Credentials are only sent over TLS unless AllowInsecureCredentials is also
+// given.
+func WithCredentials(creds credentials.PerRPCCredentials) ClientOption {
+	return func(o *clientOptions) {
+		o.perRPCCredentials = creds
+	}
+}
+
+// AllowInsecureCredentials lets credentials set with WithCredentials be sent
+// over a connection without TLS. It is meant for local development only.
+func AllowInsecureCredentials() ClientOption {
+	return func(o *clientOptions) {
+		o.allowInsecureCredentials = true
+	}
+}
+
+// perRPCDialOption returns the dial option that attaches creds.
+func perRPCDialOption(creds credentials.PerRPCCredentials, allowInsecure bool) grpc.DialOption {
+	if allowInsecure {
+		creds = insecurePerRPC{creds}
+	}
+	return grpc.WithPerRPCCredentials(creds)
+}
+
+// insecurePerRPC allows the wrapped credentials over plaintext connections.
+type insecurePerRPC struct {
+	credentials.PerRPCCredentials
+}
+
+func (insecurePerRPC) RequireTransportSecurity() bool {
+	return false
+}
+
+// staticCredentials sends the same metadata with every request.
+type staticCredentials map[string]string
+
+func (c staticCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return c, nil
+}
+
+func (staticCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// APIKey returns credentials that send key in the x-api-key header.
+func APIKey(key string) credentials.PerRPCCredentials {
+	return staticCredentials{APIKeyHeader: key}
+}
+
+// BearerToken returns credentials that send token in the authorization
+// header using the Bearer scheme.
+func BearerToken(token string) credentials.PerRPCCredentials {
+	return staticCredentials{"authorization": "Bearer " + token}
+}
+
+// Token is an access token and the time it stops being valid.
+// A zero Expiry means the token does not expire.
+type Token struct {
+	AccessToken string
+	Expiry      time.Time
+}
+
+// TokenSource fetches access tokens, for example from an OAuth2 token
+// endpoint.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// TokenSourceFunc adapts a function to a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (*Token, error)
+
+// Token calls f(ctx).
+func (f TokenSourceFunc) Token(ctx context.Context) (*Token, error) {
+	return f(ctx)
+}
+
+// RefreshingCredentials sends a bearer token obtained from a TokenSource.
+// The token is cached and fetched again shortly before it expires.
+type RefreshingCredentials struct {
+	source        TokenSource
+	refreshBefore time.Duration
+
+	mu      sync.Mutex
+	token   *Token
+	fetched time.Time
+}
+
+// NewRefreshingCredentials returns credentials that cache tokens from source
+// and refresh them refreshBefore ahead of their expiry. A refreshBefore of
+// zero uses one minute. Tokens that live less than twice refreshBefore are
+// refreshed halfway through their lifetime instead, so short-lived tokens
+// are still cached.
+func NewRefreshingCredentials(source TokenSource, refreshBefore time.Duration) *RefreshingCredentials {
+	if refreshBefore <= 0 {
+		refreshBefore = defaultRefreshBefore
+	}
+	return &RefreshingCredentials{
+		source:        source,
+		refreshBefore: refreshBefore,
+	}
+}
+
+// GetRequestMetadata returns the authorization header, refreshing the token
+// first if needed.
+func (c *RefreshingCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + token.AccessToken}, nil
+}
+
+// RequireTransportSecurity reports that tokens must only be sent over TLS.
+func (c *RefreshingCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// Token returns the cached token, fetching a new one if there is none or it
+// is about to expire.
+func (c *RefreshingCredentials) Token(ctx context.Context) (*Token, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != nil && !c.expiring(c.token) {
+		return c.token, nil
+	}
+
+	token, err := c.source.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("userup: fetching token: %w", err)
+	}
+	if token == nil || token.AccessToken == "" {
+		return nil, fmt.Errorf("userup: token source returned an empty token")
+	}
+	c.token = token
+	c.fetched = time.Now()
+	return token, nil
+}
+
+// Invalidate drops the cached token so the next call fetches a new one.
+func (c *RefreshingCredentials) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = nil
+}
+
+// expiring reports whether t, fetched at c.fetched, is due for a refresh.
+func (c *RefreshingCredentials) expiring(t *Token) bool {
+	if t.Expiry.IsZero() {
+		return false
+	}
+	margin := max(0, min(c.refreshBefore, t.Expiry.Sub(c.fetched)/2))
+	return time.Now().Add(margin).After(t.Expiry)
+}
@@ -0,0 +1,174 @@
+package userup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// countingSource hands out numbered tokens that live for ttl.
+type countingSource struct {
+	ttl   time.Duration
+	calls atomic.Int32
+}
+
+func (s *countingSource) Token(ctx context.Context) (*Token, error) {
+	n := s.calls.Add(1)
+	token := &Token{AccessToken: fmt.Sprintf("token-%d", n)}
+	if s.ttl > 0 {
+		token.Expiry = time.Now().Add(s.ttl)
+	}
+	return token, nil
+}
+
+func TestRefreshingCredentialsTiming(t *testing.T) {
+	tests := []struct {
+		name          string
+		ttl           time.Duration
+		refreshBefore time.Duration
+		wait          time.Duration
+		wantFetches   int32
+	}{
+		{"no expiry", 0, 0, 0, 1},
+		{"long-lived token is cached", time.Hour, time.Minute, 0, 1},
+		{"token shorter than refreshBefore is cached", 400 * time.Millisecond, time.Minute, 0, 1},
+		{"short-lived token is refreshed halfway", 400 * time.Millisecond, time.Minute, 250 * time.Millisecond, 2},
+		{"refreshed refreshBefore ahead of expiry", 600 * time.Millisecond, 100 * time.Millisecond, 550 * time.Millisecond, 2},
+		{"not refreshed before refreshBefore", 600 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &countingSource{ttl: tt.ttl}
+			creds := NewRefreshingCredentials(src, tt.refreshBefore)
+			ctx := context.Background()
+
+			for i := 0; i < 3; i++ {
+				if _, err := creds.Token(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			time.Sleep(tt.wait)
+			for i := 0; i < 3; i++ {
+				if _, err := creds.Token(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := src.calls.Load(); got != tt.wantFetches {
+				t.Errorf("fetched %d tokens, want %d", got, tt.wantFetches)
+			}
+		})
+	}
+}
+
+func TestRefreshingCredentialsInvalidate(t *testing.T) {
+	src := &countingSource{ttl: time.Hour}
+	creds := NewRefreshingCredentials(src, 0)
+	ctx := context.Background()
+
+	md, err := creds.GetRequestMetadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := md["authorization"]; got != "Bearer token-1" {
+		t.Errorf("authorization = %q, want Bearer token-1", got)
+	}
+	creds.Invalidate()
+	md, err = creds.GetRequestMetadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := md["authorization"]; got != "Bearer token-2" {
+		t.Errorf("after Invalidate authorization = %q, want Bearer token-2", got)
+	}
+}
+
+func TestRefreshingCredentialsErrors(t *testing.T) {
+	failure := errors.New("token endpoint down")
+	for _, src := range []TokenSourceFunc{
+		func(ctx context.Context) (*Token, error) { return nil, failure },
+		func(ctx context.Context) (*Token, error) { return nil, nil },
+		func(ctx context.Context) (*Token, error) { return &Token{}, nil },
+	} {
+		if _, err := NewRefreshingCredentials(src, 0).Token(context.Background()); err == nil {
+			t.Error("Token succeeded without a usable token")
+		}
+	}
+	_, err := NewRefreshingCredentials(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		return nil, failure
+	}), 0).Token(context.Background())
+	if !errors.Is(err, failure) {
+		t.Errorf("Token error = %v, want it to wrap the source error", err)
+	}
+}
+
+// headerServer answers Get with a user named after the credentials the
+// call carried.
+type headerServer struct {
+	userapi.UnimplementedUsersServer
+}
+
+func (headerServer) Get(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := append(md.Get(APIKeyHeader), md.Get("authorization")...)
+	return &userapi.UserResponse{Id: req.Id, Username: strings.Join(values, ",")}, nil
+}
+
+func TestCredentialsRequireTLS(t *testing.T) {
+	addr := listenTestServer(t, headerServer{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, creds := range []credentials.PerRPCCredentials{
+		APIKey("secret"),
+		BearerToken("secret"),
+		NewRefreshingCredentials(&countingSource{}, 0),
+	} {
+		client, err := NewClient(addr, WithCredentials(creds))
+		if err != nil {
+			continue // Refused when dialing.
+		}
+		_, err = client.GetUser(ctx, UID(1))
+		client.Close()
+		if err == nil {
+			t.Errorf("%T were sent over a plaintext connection", creds)
+		}
+	}
+
+	client := newTestClient(t, addr, WithCredentials(APIKey("secret")), AllowInsecureCredentials())
+	user, err := client.GetUser(ctx, UID(1))
+	if err != nil {
+		t.Fatalf("GetUser with AllowInsecureCredentials: %v", err)
+	}
+	if user.Username != "secret" {
+		t.Errorf("server saw credentials %q, want secret", user.Username)
+	}
+}
+
+func TestCredentialsOverTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverConf := ca.serverTLS(t)
+	serverConf.ClientAuth = 0
+	addr := listenTestServer(t, headerServer{}, grpc.Creds(credentials.NewTLS(serverConf)))
+	cfg := TLSConfig{CAFile: filepath.Join(t.TempDir(), "ca.pem")}
+	writeFile(t, cfg.CAFile, ca.pem)
+
+	client := newTestClient(t, addr, WithTLS(cfg), WithCredentials(BearerToken("abc")))
+	user, err := client.GetUser(context.Background(), UID(1))
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user.Username != "Bearer abc" {
+		t.Errorf("server saw credentials %q, want Bearer abc", user.Username)
+	}
+}
@@ -0,0 +1,167 @@
+package userup
+
+import (
+	"context"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// DefaultPageSize is the number of results a cursor fetches per call when
+// the query does not set a limit.
+const DefaultPageSize = 100
+
+// Cursor walks the results of a query one at a time, fetching further pages
+// as needed. Use it like bufio.Scanner:
+//
+//	c := client.UsersCursor(ctx, query)
+//	for c.Next() {
+//		user := c.Value()
+//	}
+//	if err := c.Err(); err != nil {
+//		// handle the error
+//	}
+//
+// On Go 1.23 and later, the Iter methods of UserService return the same
+// results as an iter.Seq2 for use with range.
+type Cursor[T any] struct {
+	ctx   context.Context
+	fetch func(ctx context.Context) (page []T, more bool, err error)
+
+	page []T
+	pos  int
+	more bool
+	cur  T
+	err  error
+}
+
+func newCursor[T any](ctx context.Context, fetch func(ctx context.Context) ([]T, bool, error)) *Cursor[T] {
+	return &Cursor[T]{ctx: ctx, fetch: fetch, more: true}
+}
+
+// Next advances to the next result, which is then available through Value.
+// It returns false at the end of the results, when a call fails or when the
+// context is done; Err tells these apart.
+func (c *Cursor[T]) Next() bool {
+	if c.err != nil {
+		return false
+	}
+	if err := c.ctx.Err(); err != nil {
+		c.err = err
+		return false
+	}
+	for c.pos >= len(c.page) {
+		if !c.more {
+			return false
+		}
+		c.page, c.more, c.err = c.fetch(c.ctx)
+		c.pos = 0
+		if c.err != nil {
+			return false
+		}
+	}
+	c.cur = c.page[c.pos]
+	c.pos++
+	return true
+}
+
+// Value returns the current result.
+func (c *Cursor[T]) Value() T {
+	return c.cur
+}
+
+// Err returns the error that stopped the cursor, or nil if it reached the
+// end of the results.
+func (c *Cursor[T]) Err() error {
+	return c.err
+}
+
+// offsetPager fetches pages of up to limit results by advancing an offset
+// past the results received. Servers may cap the page size below limit, so
+// a short page does not mean the end: only an empty page does, at the cost
+// of one more call.
+func offsetPager[T any](limit, offset int, fetch func(ctx context.Context, limit, offset int) ([]T, error)) func(context.Context) ([]T, bool, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	return func(ctx context.Context) ([]T, bool, error) {
+		page, err := fetch(ctx, limit, offset)
+		if err != nil {
+			return nil, false, err
+		}
+		offset += len(page)
+		return page, len(page) > 0, nil
+	}
+}
+
+// UsersCursor returns a Cursor over the users matching query, fetched with
+// QueryUsers. query.Limit sets the page size, DefaultPageSize if zero, and
+// query.Offset where to start. query itself is not modified; a nil query
+// matches every user.
+func (us UserService) UsersCursor(ctx context.Context, query *Query) *Cursor[*User] {
+	var q Query
+	if query != nil {
+		q = *query
+	}
+	return newCursor(ctx, offsetPager(q.Limit, q.Offset, func(ctx context.Context, limit, offset int) ([]*User, error) {
+		q.Limit, q.Offset = limit, offset
+		return us.QueryUsers(ctx, &q)
+	}))
+}
+
+// EventsCursor returns a Cursor over the events matching query, fetched with
+// QueryEvents. Paging works as for UsersCursor.
+func (us UserService) EventsCursor(ctx context.Context, query *Query) *Cursor[Event] {
+	var q Query
+	if query != nil {
+		q = *query
+	}
+	return newCursor(ctx, offsetPager(q.Limit, q.Offset, func(ctx context.Context, limit, offset int) ([]Event, error) {
+		q.Limit, q.Offset = limit, offset
+		return us.QueryEvents(ctx, &q)
+	}))
+}
+
+// SessionsCursor returns a Cursor over the sessions matching query, fetched
+// with GetSessions. Paging works as for UsersCursor.
+func (us UserService) SessionsCursor(ctx context.Context, query *SessionQuery) *Cursor[*userapi.Session] {
+	var q SessionQuery
+	if query != nil {
+		q = *query
+	}
+	return newCursor(ctx, offsetPager(int(q.Limit), int(q.Offset), func(ctx context.Context, limit, offset int) ([]*userapi.Session, error) {
+		q.Limit, q.Offset = int32(limit), int32(offset)
+		return us.GetSessions(ctx, &q)
+	}))
+}
+
+// SessionEventsCursor returns a Cursor over the session events matching
+// query, fetched with GetSessionEvents. Paging works as for UsersCursor.
+func (us UserService) SessionEventsCursor(ctx context.Context, query *SessionEventQuery) *Cursor[*userapi.Event] {
+	var q SessionEventQuery
+	if query != nil {
+		q = *query
+	}
+	return newCursor(ctx, offsetPager(int(q.Limit), int(q.Offset), func(ctx context.Context, limit, offset int) ([]*userapi.Event, error) {
+		q.Limit, q.Offset = int32(limit), int32(offset)
+		return us.GetSessionEvents(ctx, &q)
+	}))
+}
+
+// FindUsersCursor returns a Cursor over the users matching usp, fetched with
+// FindUser DefaultPageSize at a time using the server's page tokens.
+func (us UserService) FindUsersCursor(ctx context.Context, usp *UserSearchParams) *Cursor[*User] {
+	query := UserSearchToUserQuery(usp)
+	query.PageSize = DefaultPageSize
+	return newCursor(ctx, func(ctx context.Context) ([]*User, bool, error) {
+		resp, err := us.client.Find(ctx, query)
+		if err != nil {
+			return nil, false, err
+		}
+		users, err := usersFromResponse(userapi.Users_Find_FullMethodName, resp.Users)
+		if err != nil {
+			return nil, false, err
+		}
+		query.PageToken = resp.NextPageToken
+		return users, resp.NextPageToken != "", nil
+	})
+}
@@ -0,0 +1,93 @@
+package userup
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// pagingServer serves users 1 to total from QueryUsers, never more than
+// maxPage at a time.
+type pagingServer struct {
+	userapi.UnimplementedUsersServer
+	total   int
+	maxPage int
+	calls   int
+}
+
+func (s *pagingServer) QueryUsers(ctx context.Context, req *userapi.QueryRequest) (*userapi.UserListResponse, error) {
+	s.calls++
+	var q Query
+	if err := json.Unmarshal(req.Query, &q); err != nil {
+		return nil, err
+	}
+	resp := &userapi.UserListResponse{}
+	for id := q.Offset + 1; id <= s.total && len(resp.Users) < min(q.Limit, s.maxPage); id++ {
+		resp.Users = append(resp.Users, &userapi.UserResponse{Id: &userapi.UserID{Id: uint64(id)}})
+	}
+	return resp, nil
+}
+
+func TestUsersCursorPages(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     *Query
+		total     int
+		maxPage   int
+		wantFirst uint64
+		wantCount int
+		wantCalls int
+	}{
+		{"nil query", nil, 25, 1000, 1, 25, 1 + 1},
+		{"full pages", &Query{Limit: 10}, 25, 1000, 1, 25, 3 + 1},
+		{"server caps the page size", &Query{Limit: 10}, 25, 4, 1, 25, 7 + 1},
+		{"offset", &Query{Limit: 10, Offset: 20}, 25, 1000, 21, 5, 1 + 1},
+		{"no results", &Query{}, 0, 1000, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &pagingServer{total: tt.total, maxPage: tt.maxPage}
+			client := startTestServer(t, srv)
+
+			c := client.UsersCursor(context.Background(), tt.query)
+			var ids []uint64
+			for c.Next() {
+				ids = append(ids, c.Value().ID.ID)
+			}
+			if err := c.Err(); err != nil {
+				t.Fatalf("Err() = %v", err)
+			}
+			if len(ids) != tt.wantCount {
+				t.Fatalf("got %d users, want %d", len(ids), tt.wantCount)
+			}
+			for i, id := range ids {
+				if id != tt.wantFirst+uint64(i) {
+					t.Fatalf("user %d has ID %d, want %d", i, id, tt.wantFirst+uint64(i))
+				}
+			}
+			if srv.calls != tt.wantCalls {
+				t.Errorf("made %d calls, want %d", srv.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCursorStopsWhenContextIsDone(t *testing.T) {
+	srv := &pagingServer{total: 25, maxPage: 1000}
+	client := startTestServer(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := client.UsersCursor(ctx, &Query{Limit: 10})
+	if !c.Next() {
+		t.Fatalf("Next() = false, Err() = %v", c.Err())
+	}
+	cancel()
+	if c.Next() {
+		t.Fatal("Next() = true after cancel")
+	}
+	if c.Err() != context.Canceled {
+		t.Errorf("Err() = %v, want context.Canceled", c.Err())
+	}
+}
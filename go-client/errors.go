@@ -0,0 +1,133 @@
+package userup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Sentinel errors for the common failure classes. Errors returned by the
+// client match them with errors.Is.
+var (
+	ErrNotFound         = errors.New("userup: not found")
+	ErrAlreadyExists    = errors.New("userup: already exists")
+	ErrInvalidArgument  = errors.New("userup: invalid argument")
+	ErrUnavailable      = errors.New("userup: service unavailable")
+	ErrPermissionDenied = errors.New("userup: permission denied")
+	ErrTimeout          = errors.New("userup: timeout")
+	ErrConflict         = errors.New("userup: conflicting update")
+)
+
+// Error is returned by UserService and IntegrationsService methods when a
+// call fails, whether on the server or in SDK-side validation.
+type Error struct {
+	Method  string         // Full gRPC method name of the call, e.g. "/userapi.Users/Get".
+	Status  *status.Status // Status returned by the server, or built by the SDK.
+	Details []interface{}  // Decoded status details, if any.
+
+	cause error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("userup: %s: %s: %s", e.Method, e.Status.Code(), e.Status.Message())
+}
+
+// Code returns the gRPC status code of the error.
+func (e *Error) Code() codes.Code {
+	return e.Status.Code()
+}
+
+// GRPCStatus returns the status, so status.FromError and status.Code keep
+// working on wrapped errors.
+func (e *Error) GRPCStatus() *status.Status {
+	return e.Status
+}
+
+// Is reports whether the error belongs to the class of target.
+// Unauthenticated errors match ErrPermissionDenied, Aborted and
+// FailedPrecondition errors match ErrConflict, calls that ran out of time
+// match both ErrTimeout and context.DeadlineExceeded, and cancelled calls
+// match context.Canceled.
+func (e *Error) Is(target error) bool {
+	switch e.Status.Code() {
+	case codes.NotFound:
+		return target == ErrNotFound
+	case codes.AlreadyExists:
+		return target == ErrAlreadyExists
+	case codes.InvalidArgument:
+		return target == ErrInvalidArgument
+	case codes.Unavailable:
+		return target == ErrUnavailable
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return target == ErrPermissionDenied
+	case codes.Aborted, codes.FailedPrecondition:
+		return target == ErrConflict
+	case codes.Canceled:
+		return target == context.Canceled
+	case codes.DeadlineExceeded:
+		return target == ErrTimeout || target == context.DeadlineExceeded
+	}
+	return false
+}
+
+// Unwrap returns the SDK-side error that caused a validation failure, if any.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// newError wraps err, returned by the call to method, in an *Error.
+func newError(method string, err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	st := status.Convert(err)
+	return &Error{
+		Method:  method,
+		Status:  st,
+		Details: st.Details(),
+	}
+}
+
+// invalidArgument reports an SDK-side validation failure for method.
+func invalidArgument(method string, cause error) error {
+	return &Error{
+		Method: method,
+		Status: status.New(codes.InvalidArgument, cause.Error()),
+		cause:  cause,
+	}
+}
+
+// invalidResponse reports a response from method that the SDK could not
+// convert, such as one carrying a malformed user UUID.
+func invalidResponse(method string, cause error) error {
+	return &Error{
+		Method: method,
+		Status: status.New(codes.Internal, cause.Error()),
+		cause:  cause,
+	}
+}
+
+// conflict reports that a conditional update to method found the user
+// changed.
+func conflict(method string, cause error) error {
+	return &Error{
+		Method: method,
+		Status: status.New(codes.Aborted, cause.Error()),
+		cause:  cause,
+	}
+}
+
+// errorInterceptor converts the errors of every call into *Error.
+func errorInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err != nil {
+		return newError(method, err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package userup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+var sentinels = []error{
+	ErrNotFound, ErrAlreadyExists, ErrInvalidArgument, ErrUnavailable,
+	ErrPermissionDenied, ErrTimeout, ErrConflict, context.Canceled, context.DeadlineExceeded,
+}
+
+func TestErrorIs(t *testing.T) {
+	tests := []struct {
+		code codes.Code
+		want []error
+	}{
+		{codes.NotFound, []error{ErrNotFound}},
+		{codes.AlreadyExists, []error{ErrAlreadyExists}},
+		{codes.InvalidArgument, []error{ErrInvalidArgument}},
+		{codes.Unavailable, []error{ErrUnavailable}},
+		{codes.PermissionDenied, []error{ErrPermissionDenied}},
+		{codes.Unauthenticated, []error{ErrPermissionDenied}},
+		{codes.Aborted, []error{ErrConflict}},
+		{codes.FailedPrecondition, []error{ErrConflict}},
+		{codes.DeadlineExceeded, []error{ErrTimeout, context.DeadlineExceeded}},
+		{codes.Canceled, []error{context.Canceled}},
+		{codes.Internal, nil},
+		{codes.Unknown, nil},
+	}
+	for _, tt := range tests {
+		err := newError(userapi.Users_Get_FullMethodName, status.Error(tt.code, "boom"))
+		wrapped := fmt.Errorf("loading profile: %w", err)
+		for _, sentinel := range sentinels {
+			want := false
+			for _, w := range tt.want {
+				want = want || w == sentinel
+			}
+			if got := errors.Is(wrapped, sentinel); got != want {
+				t.Errorf("%s: errors.Is(err, %v) = %v, want %v", tt.code, sentinel, got, want)
+			}
+		}
+		if got := status.Code(wrapped); got != tt.code {
+			t.Errorf("%s: status.Code of the wrapped error = %s", tt.code, got)
+		}
+	}
+}
+
+func TestErrorInterceptor(t *testing.T) {
+	client := startTestServer(t, &failingServer{code: codes.NotFound, failures: 1})
+
+	_, err := client.GetUser(context.Background(), UID(1))
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("GetUser error = %v (%T), want an *Error", err, err)
+	}
+	if e.Method != userapi.Users_Get_FullMethodName {
+		t.Errorf("Method = %q, want %q", e.Method, userapi.Users_Get_FullMethodName)
+	}
+	if e.Code() != codes.NotFound || e.Status.Message() != "try again" {
+		t.Errorf("Status = %v, want NotFound: try again", e.Status)
+	}
+	want := "userup: /userapi.Users/Get: NotFound: try again"
+	if e.Error() != want {
+		t.Errorf("Error() = %q, want %q", e.Error(), want)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("GetUser error does not match ErrNotFound")
+	}
+}
+
+func TestSDKErrors(t *testing.T) {
+	cause := errors.New("bad input")
+	tests := []struct {
+		err      error
+		code     codes.Code
+		sentinel error
+	}{
+		{invalidArgument("m", cause), codes.InvalidArgument, ErrInvalidArgument},
+		{invalidResponse("m", cause), codes.Internal, nil},
+		{conflict("m", cause), codes.Aborted, ErrConflict},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, cause) {
+			t.Errorf("%v does not unwrap to its cause", tt.err)
+		}
+		if status.Code(tt.err) != tt.code {
+			t.Errorf("%v has code %s, want %s", tt.err, status.Code(tt.err), tt.code)
+		}
+		if tt.sentinel != nil && !errors.Is(tt.err, tt.sentinel) {
+			t.Errorf("%v does not match %v", tt.err, tt.sentinel)
+		}
+	}
+
+	// Errors that already are an *Error are passed on unchanged.
+	if err := invalidArgument("m", cause); newError("other", err) != err {
+		t.Error("newError rewrapped an *Error")
+	}
+}
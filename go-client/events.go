@@ -55,7 +55,7 @@ func (e EventLogger) LogEvent(ctx context.Context, event Event) (*Event, error)
 	}
 
 	if event.Type == "" {
-		return nil, fmt.Errorf("`Type` is required for the event")
+		return nil, invalidArgument(userapi.Users_LogEvent_FullMethodName, fmt.Errorf("`Type` is required for the event"))
 	}
 
 	if event.ID == "" {
@@ -76,7 +76,7 @@ func (e EventLogger) LogEvent(ctx context.Context, event Event) (*Event, error)
 
 	jsonData, err := json.Marshal(event.Data)
 	if err != nil {
-		return nil, err
+		return nil, invalidArgument(userapi.Users_LogEvent_FullMethodName, err)
 	}
 
 	apiEvent := &userapi.Event{
@@ -97,17 +97,9 @@ func (e EventLogger) LogEvent(ctx context.Context, event Event) (*Event, error)
 	if err != nil {
 		return nil, err
 	}
-	return &Event{
-		Timestamp:       eventResp.Event.Timestamp.AsTime(),
-		ID:              eventResp.Event.Id,
-		Source:          eventResp.Event.Source,
-		SpecVersion:     eventResp.Event.Specversion,
-		Type:            eventResp.Event.Type,
-		DataContentType: eventResp.Event.Datacontenttype,
-		DataSchema:      eventResp.Event.Dataschema,
-		Subject:         eventResp.Event.Subject,
-		Data:            eventResp.Event.Data,
-		SessionKey:      eventResp.Event.SessionKey,
-		UserID:          clientUserID(eventResp.Event.UserId),
-	}, nil
+	loggedEvent, err := eventFromProto(eventResp.Event)
+	if err != nil {
+		return nil, invalidResponse(userapi.Users_LogEvent_FullMethodName, err)
+	}
+	return &loggedEvent, nil
 }
@@ -0,0 +1,88 @@
+package userup
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// WithHealthService sets the service name Ping asks the server about.
+// The default is the empty name, which reports on the server as a whole.
+// With load balancing it also turns on client-side health checking of every
+// endpoint.
+func WithHealthService(name string) ClientOption {
+	return func(o *clientOptions) {
+		o.healthService = name
+		o.healthCheck = true
+	}
+}
+
+// WithStateChangeHandler registers fn to be called each time the connection
+// changes state, for example from READY to TRANSIENT_FAILURE. fn is called
+// from a separate goroutine and for the last time with SHUTDOWN once the
+// client is closed.
+func WithStateChangeHandler(fn func(connectivity.State)) ClientOption {
+	return func(o *clientOptions) {
+		o.onStateChange = fn
+	}
+}
+
+// Ping checks that the user service is serving, using the standard gRPC
+// health checking protocol. It returns nil if the server reports SERVING.
+func (us UserService) Ping(ctx context.Context) error {
+	resp, err := healthpb.NewHealthClient(us.conn).Check(ctx, &healthpb.HealthCheckRequest{
+		Service: us.opts.healthService,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return &Error{
+			Method: healthpb.Health_Check_FullMethodName,
+			Status: status.New(codes.Unavailable, fmt.Sprintf("service is %s", resp.Status)),
+		}
+	}
+	return nil
+}
+
+// WaitReady blocks until the connection is READY or ctx is done. It starts
+// connecting if the connection is idle.
+func (us UserService) WaitReady(ctx context.Context) error {
+	return waitReady(ctx, us.conn)
+}
+
+func waitReady(ctx context.Context, conn *grpc.ClientConn) error {
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Shutdown:
+			return &Error{
+				Method: "WaitReady",
+				Status: status.New(codes.Canceled, "client is closed"),
+			}
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return ctx.Err()
+		}
+	}
+}
+
+// watchState calls fn on every state change of conn until it shuts down.
+func watchState(conn *grpc.ClientConn, fn func(connectivity.State)) {
+	state := conn.GetState()
+	for conn.WaitForStateChange(context.Background(), state) {
+		state = conn.GetState()
+		fn(state)
+		if state == connectivity.Shutdown {
+			return
+		}
+	}
+}
@@ -4,8 +4,6 @@ import (
 	"context"
 	"time"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/protobuf/types/known/structpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
@@ -90,10 +88,12 @@ func (is *IntegrationsService) Close() {
 
 // NewIntegrationsClient creates a new instance of the UserService Integrations client.
 // It establishes a gRPC connection to the specified URI and returns the client.
-// The URI should be in the format "host:port".
+// The URI should be in the format "host:port", a comma separated list of
+// such addresses, or a gRPC target such as "dns:///host:port".
+// Options can be supplied to tune the underlying connection.
 // If the connection cannot be established, an error is returned.
-func NewIntegrationsClient(uri string) (*IntegrationsService, error) {
-	conn, err := grpc.Dial(uri, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func NewIntegrationsClient(uri string, opts ...ClientOption) (*IntegrationsService, error) {
+	conn, err := dial(uri, newClientOptions(opts))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,100 @@
+package userup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader is the metadata key RequestIDInterceptor sends request IDs
+// in.
+const RequestIDHeader = "x-request-id"
+
+// WithInterceptors adds interceptors that run around every call made by the
+// client, in the order given. They see the full gRPC method name, the request
+// and the response, and errors returned to them are already *Error values.
+func WithInterceptors(interceptors ...grpc.UnaryClientInterceptor) ClientOption {
+	return func(o *clientOptions) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the request ID id, for
+// RequestIDInterceptor to send along with calls made with it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID set with WithRequestID or,
+// failing that, the one received in the incoming gRPC metadata of ctx.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok && id != "" {
+		return id, true
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDHeader); len(ids) > 0 && ids[0] != "" {
+			return ids[0], true
+		}
+	}
+	return "", false
+}
+
+// RequestIDInterceptor sends the request ID found in the call's context in
+// the x-request-id header.
+func RequestIDInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if id, ok := RequestIDFromContext(ctx); ok {
+			ctx = metadata.AppendToOutgoingContext(ctx, RequestIDHeader, id)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// MetadataInterceptor sends the given key/value pairs, such as a tenant
+// header, with every call. kv must have an even number of elements;
+// MetadataInterceptor panics otherwise.
+func MetadataInterceptor(kv ...string) grpc.UnaryClientInterceptor {
+	if len(kv)%2 == 1 {
+		panic(fmt.Sprintf("userup: MetadataInterceptor got an odd number of key/value elements: %d", len(kv)))
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, kv...)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// LoggingInterceptor logs every call with its method, duration and status
+// code. Failed calls are logged at error level, the rest at debug level.
+// A nil logger uses slog.Default().
+func LoggingInterceptor(logger *slog.Logger) grpc.UnaryClientInterceptor {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		attrs := []slog.Attr{
+			slog.String("method", method),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("code", status.Code(err).String()),
+		}
+		if id, ok := RequestIDFromContext(ctx); ok {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+			logger.LogAttrs(ctx, slog.LevelError, "userup call failed", attrs...)
+		} else {
+			logger.LogAttrs(ctx, slog.LevelDebug, "userup call", attrs...)
+		}
+		return err
+	}
+}
@@ -0,0 +1,65 @@
+//go:build go1.23
+
+package userup
+
+import (
+	"context"
+	"iter"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// IterUsers returns the users matching query for use with range, fetching
+// pages as the loop goes. A failed call or a done context ends the loop with
+// a nil user and the error. Paging works as for UsersCursor.
+//
+//	for user, err := range client.IterUsers(ctx, query) {
+//		if err != nil {
+//			return err
+//		}
+//		// use user
+//	}
+func (us UserService) IterUsers(ctx context.Context, query *Query) iter.Seq2[*User, error] {
+	return cursorSeq(func() *Cursor[*User] { return us.UsersCursor(ctx, query) })
+}
+
+// IterEvents returns the events matching query for use with range. See
+// IterUsers.
+func (us UserService) IterEvents(ctx context.Context, query *Query) iter.Seq2[Event, error] {
+	return cursorSeq(func() *Cursor[Event] { return us.EventsCursor(ctx, query) })
+}
+
+// IterSessions returns the sessions matching query for use with range. See
+// IterUsers.
+func (us UserService) IterSessions(ctx context.Context, query *SessionQuery) iter.Seq2[*userapi.Session, error] {
+	return cursorSeq(func() *Cursor[*userapi.Session] { return us.SessionsCursor(ctx, query) })
+}
+
+// IterSessionEvents returns the session events matching query for use with
+// range. See IterUsers.
+func (us UserService) IterSessionEvents(ctx context.Context, query *SessionEventQuery) iter.Seq2[*userapi.Event, error] {
+	return cursorSeq(func() *Cursor[*userapi.Event] { return us.SessionEventsCursor(ctx, query) })
+}
+
+// IterFindUsers returns the users matching usp for use with range. See
+// IterUsers and FindUsersCursor.
+func (us UserService) IterFindUsers(ctx context.Context, usp *UserSearchParams) iter.Seq2[*User, error] {
+	return cursorSeq(func() *Cursor[*User] { return us.FindUsersCursor(ctx, usp) })
+}
+
+// cursorSeq adapts the cursors made by newCursor to iter.Seq2. Each range
+// over the sequence starts a new cursor.
+func cursorSeq[T any](newCursor func() *Cursor[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		c := newCursor()
+		for c.Next() {
+			if !yield(c.Value(), nil) {
+				return
+			}
+		}
+		if err := c.Err(); err != nil {
+			var zero T
+			yield(zero, err)
+		}
+	}
+}
@@ -0,0 +1,565 @@
+package userup
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MarshalUser builds a User from v, a struct or pointer to struct whose
+// fields carry `userup` tags:
+//
+//	type Customer struct {
+//		ID       userup.UserID `userup:"id"`
+//		Username string        `userup:"username"`
+//		Email    string        `userup:"attr,email"`
+//		Plan     *string       `userup:"trait,plan,omitempty"`
+//		Address  Address       `userup:"attr,address"`
+//	}
+//
+// Attributes and traits may hold strings, booleans, numbers, time.Time
+// values (stored as RFC 3339 strings), pointers, slices, string-keyed maps
+// and nested structs. Fields of nested structs are named by their `userup`
+// tag, or by their Go name when untagged. Untagged fields of the top level
+// struct are ignored, except for embedded structs, exported or not, whose
+// fields are mapped as if they belonged to the outer struct. The omitempty
+// option leaves out zero values.
+func MarshalUser(v interface{}) (*User, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("userup: MarshalUser of nil %T", v)
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("userup: MarshalUser of non-struct %T", v)
+	}
+
+	user := &User{
+		Attributes: make(map[string]interface{}),
+		Traits:     make(map[string]interface{}),
+	}
+	if err := marshalUserFields(rv, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UnmarshalUser copies the username, ID, attributes and traits of u into the
+// struct v points to, following the same `userup` tags as MarshalUser.
+// Fields whose key is missing from u are left unchanged. Numbers are
+// converted to the numeric kind of the field as long as they fit, and RFC
+// 3339 strings are parsed into time.Time fields.
+func UnmarshalUser(u *User, v interface{}) error {
+	if u == nil {
+		return fmt.Errorf("userup: UnmarshalUser of nil *User")
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("userup: UnmarshalUser needs a non-nil pointer, got %T", v)
+	}
+	rv = rv.Elem()
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("userup: UnmarshalUser needs a pointer to a struct, got %T", v)
+	}
+	return unmarshalUserFields(u, rv)
+}
+
+// MappingError reports a value that could not be converted between a User
+// and a Go value.
+type MappingError struct {
+	Path  string       // Location of the value, e.g. "attributes.address.zip".
+	Type  reflect.Type // Go type of the field involved.
+	Value interface{}  // The value that could not be converted.
+}
+
+func (e *MappingError) Error() string {
+	return fmt.Sprintf("userup: cannot convert %s: %T value %v into %s", e.Path, e.Value, e.Value, e.Type)
+}
+
+// Kinds of top level `userup` tags.
+const (
+	tagAttr     = "attr"
+	tagTrait    = "trait"
+	tagUsername = "username"
+	tagID       = "id"
+)
+
+// fieldTag is a parsed `userup` struct tag.
+type fieldTag struct {
+	kind      string
+	name      string
+	omitEmpty bool
+}
+
+// parseTopLevelTag parses tags of the form "attr,name[,omitempty]",
+// "trait,name[,omitempty]", "username" or "id".
+func parseTopLevelTag(f reflect.StructField) (fieldTag, bool) {
+	tag, ok := f.Tag.Lookup("userup")
+	if !ok || tag == "-" {
+		return fieldTag{}, false
+	}
+	parts := strings.Split(tag, ",")
+	ft := fieldTag{kind: parts[0]}
+	if ft.kind == tagAttr || ft.kind == tagTrait {
+		if len(parts) > 1 {
+			ft.name = parts[1]
+			parts = parts[1:]
+		}
+		if ft.name == "" {
+			ft.name = f.Name
+		}
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			ft.omitEmpty = true
+		}
+	}
+	return ft, true
+}
+
+// parseNestedTag parses the tags of fields of nested structs, which have the
+// form "name[,omitempty]".
+func parseNestedTag(f reflect.StructField) (fieldTag, bool) {
+	tag := f.Tag.Get("userup")
+	if tag == "-" {
+		return fieldTag{}, false
+	}
+	parts := strings.Split(tag, ",")
+	ft := fieldTag{name: parts[0]}
+	if ft.name == "" {
+		ft.name = f.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			ft.omitEmpty = true
+		}
+	}
+	return ft, true
+}
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	userIDType = reflect.TypeOf(UserID{})
+)
+
+func marshalUserFields(rv reflect.Value, user *User) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		fv := rv.Field(i)
+
+		ft, ok := parseTopLevelTag(f)
+		if !ok {
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				if err := marshalUserFields(fv, user); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		switch ft.kind {
+		case tagID:
+			id, ok := fv.Interface().(UserID)
+			if !ok {
+				return &MappingError{Path: "id", Type: userIDType, Value: fv.Interface()}
+			}
+			user.ID = id
+		case tagUsername:
+			if fv.Kind() != reflect.String {
+				return &MappingError{Path: "username", Type: reflect.TypeOf(""), Value: fv.Interface()}
+			}
+			user.Username = fv.String()
+		case tagAttr, tagTrait:
+			if ft.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			target, path := user.Attributes, "attributes."+ft.name
+			if ft.kind == tagTrait {
+				target, path = user.Traits, "traits."+ft.name
+			}
+			val, err := encodeField(path, fv)
+			if err != nil {
+				return err
+			}
+			target[ft.name] = val
+		default:
+			return fmt.Errorf("userup: unknown tag kind %q on field %s", ft.kind, f.Name)
+		}
+	}
+	return nil
+}
+
+func unmarshalUserFields(u *User, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		fv := rv.Field(i)
+
+		ft, ok := parseTopLevelTag(f)
+		if !ok {
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				if err := unmarshalUserFields(u, fv); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		switch ft.kind {
+		case tagID:
+			if fv.Type() != userIDType {
+				return &MappingError{Path: "id", Type: fv.Type(), Value: u.ID}
+			}
+			fv.Set(reflect.ValueOf(u.ID))
+		case tagUsername:
+			if err := decodeValue("username", u.Username, fv); err != nil {
+				return err
+			}
+		case tagAttr, tagTrait:
+			source, path := u.Attributes, "attributes."+ft.name
+			if ft.kind == tagTrait {
+				source, path = u.Traits, "traits."+ft.name
+			}
+			val, ok := source[ft.name]
+			if !ok {
+				continue
+			}
+			if err := decodeValue(path, val, fv); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("userup: unknown tag kind %q on field %s", ft.kind, f.Name)
+		}
+	}
+	return nil
+}
+
+// encodeField converts a Go value into the plain form stored in attributes
+// and traits: nil, bool, int64, uint64, float64, string, []interface{} or
+// map[string]interface{}.
+func encodeField(path string, v reflect.Value) (interface{}, error) {
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(time.RFC3339Nano), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return encodeField(path, v.Elem())
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			item, err := encodeField(fmt.Sprintf("%s[%d]", path, i), v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = item
+		}
+		return list, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, &MappingError{Path: path, Type: v.Type(), Value: v.Interface()}
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			item, err := encodeField(path+"."+key, iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			m[key] = item
+		}
+		return m, nil
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		rt := v.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			f := rt.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			ft, ok := parseNestedTag(f)
+			if !ok {
+				continue
+			}
+			fv := v.Field(i)
+			if ft.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			item, err := encodeField(path+"."+ft.name, fv)
+			if err != nil {
+				return nil, err
+			}
+			m[ft.name] = item
+		}
+		return m, nil
+	}
+	return nil, &MappingError{Path: path, Type: v.Type(), Value: v.Interface()}
+}
+
+// decodeValue stores src, a value held in attributes or traits, into dst.
+func decodeValue(path string, src interface{}, dst reflect.Value) error {
+	mismatch := &MappingError{Path: path, Type: dst.Type(), Value: src}
+
+	if dst.Kind() == reflect.Pointer {
+		if src == nil {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		elem := reflect.New(dst.Type().Elem())
+		if err := decodeValue(path, src, elem.Elem()); err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	}
+
+	if dst.Kind() == reflect.Interface && dst.NumMethod() == 0 {
+		if src == nil {
+			dst.Set(reflect.Zero(dst.Type()))
+		} else {
+			dst.Set(reflect.ValueOf(src))
+		}
+		return nil
+	}
+
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	if dst.Type() == timeType {
+		switch s := src.(type) {
+		case time.Time:
+			dst.Set(reflect.ValueOf(s))
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return mismatch
+			}
+			dst.Set(reflect.ValueOf(t))
+		default:
+			return mismatch
+		}
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.String:
+		s, ok := src.(string)
+		if !ok {
+			return mismatch
+		}
+		dst.SetString(s)
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return mismatch
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := toInt64(src)
+		if !ok || dst.OverflowInt(n) {
+			return mismatch
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := toUint64(src)
+		if !ok || dst.OverflowUint(n) {
+			return mismatch
+		}
+		dst.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat64(src)
+		if !ok || dst.OverflowFloat(f) {
+			return mismatch
+		}
+		dst.SetFloat(f)
+	case reflect.Slice:
+		sv := reflect.ValueOf(src)
+		if sv.Kind() != reflect.Slice && sv.Kind() != reflect.Array {
+			return mismatch
+		}
+		list := reflect.MakeSlice(dst.Type(), sv.Len(), sv.Len())
+		for i := 0; i < sv.Len(); i++ {
+			if err := decodeValue(fmt.Sprintf("%s[%d]", path, i), sv.Index(i).Interface(), list.Index(i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(list)
+	case reflect.Map:
+		m, ok := src.(map[string]interface{})
+		if !ok || dst.Type().Key().Kind() != reflect.String {
+			return mismatch
+		}
+		out := reflect.MakeMapWithSize(dst.Type(), len(m))
+		for k, v := range m {
+			item := reflect.New(dst.Type().Elem()).Elem()
+			if err := decodeValue(path+"."+k, v, item); err != nil {
+				return err
+			}
+			out.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), item)
+		}
+		dst.Set(out)
+	case reflect.Struct:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return mismatch
+		}
+		rt := dst.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			f := rt.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			ft, ok := parseNestedTag(f)
+			if !ok {
+				continue
+			}
+			v, ok := m[ft.name]
+			if !ok {
+				continue
+			}
+			if err := decodeValue(path+"."+ft.name, v, dst.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return mismatch
+	}
+	return nil
+}
+
+// toInt64 converts a numeric value to int64 if it is a whole number in range.
+// Integers beyond 2^53 written as decimal strings by LargeIntegersAsStrings
+// are accepted too; other strings are not numbers.
+func toInt64(v interface{}) (int64, bool) {
+	if s, ok := v.(string); ok {
+		if !isLargeIntegerString(s) {
+			return 0, false
+		}
+		i, err := strconv.ParseInt(s, 10, 64)
+		return i, err == nil
+	}
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i, true
+		}
+		f, err := n.Float64()
+		return toInt64IfWhole(f, err == nil)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n := rv.Uint()
+		return int64(n), n <= math.MaxInt64
+	case reflect.Float32, reflect.Float64:
+		return toInt64IfWhole(rv.Float(), true)
+	}
+	return 0, false
+}
+
+func toInt64IfWhole(f float64, ok bool) (int64, bool) {
+	if !ok || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// toUint64 converts a numeric value to uint64 if it is a non-negative whole
+// number in range. Decimal strings are accepted as for toInt64.
+func toUint64(v interface{}) (uint64, bool) {
+	if s, ok := v.(string); ok {
+		if !isLargeIntegerString(s) {
+			return 0, false
+		}
+		u, err := strconv.ParseUint(s, 10, 64)
+		return u, err == nil
+	}
+	if n, ok := v.(json.Number); ok {
+		if u, err := strconv.ParseUint(n.String(), 10, 64); err == nil {
+			return u, true
+		}
+		f, err := n.Float64()
+		if err != nil || f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+			return 0, false
+		}
+		return uint64(f), true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := rv.Int()
+		return uint64(n), n >= 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+			return 0, false
+		}
+		return uint64(f), true
+	}
+	return 0, false
+}
+
+// toFloat64 converts any numeric value to float64.
+func toFloat64(v interface{}) (float64, bool) {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// isEmptyValue reports whether v counts as empty for omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
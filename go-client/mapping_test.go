@@ -0,0 +1,215 @@
+package userup
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testAddress struct {
+	Street string `userup:"street"`
+	Zip    int    `userup:"zip,omitempty"`
+	Note   string `userup:"-"`
+	City   string
+}
+
+type testAudit struct {
+	CreatedAt time.Time `userup:"attr,created_at"`
+}
+
+type testCustomer struct {
+	testAudit
+	ID       UserID            `userup:"id"`
+	Username string            `userup:"username"`
+	Email    string            `userup:"attr,email"`
+	Age      *int              `userup:"attr,age"`
+	Nickname *string           `userup:"attr,nickname,omitempty"`
+	Tags     []string          `userup:"attr,tags,omitempty"`
+	Address  testAddress       `userup:"attr,address"`
+	Plan     string            `userup:"trait,plan"`
+	Limits   map[string]uint16 `userup:"trait,limits"`
+	Ignored  string
+	internal string `userup:"attr,internal"`
+}
+
+func TestMarshalUser(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	age := 42
+	c := testCustomer{
+		testAudit: testAudit{CreatedAt: created},
+		ID:        UID(7),
+		Username:  "jane",
+		Email:     "jane@example.com",
+		Age:       &age,
+		Address:   testAddress{Street: "Main St", Note: "private", City: "Springfield"},
+		Plan:      "pro",
+		Limits:    map[string]uint16{"seats": 5},
+		Ignored:   "x",
+		internal:  "y",
+	}
+	got, err := MarshalUser(&c)
+	if err != nil {
+		t.Fatalf("MarshalUser: %v", err)
+	}
+	want := &User{
+		ID:       UID(7),
+		Username: "jane",
+		Attributes: map[string]interface{}{
+			"created_at": "2024-05-01T12:30:00Z",
+			"email":      "jane@example.com",
+			"age":        int64(42),
+			"address":    map[string]interface{}{"street": "Main St", "City": "Springfield"},
+		},
+		Traits: map[string]interface{}{
+			"plan":   "pro",
+			"limits": map[string]interface{}{"seats": uint64(5)},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MarshalUser =\n%#v\nwant\n%#v", got, want)
+	}
+}
+
+func TestUnmarshalUser(t *testing.T) {
+	u := &User{
+		ID:       UID(7),
+		Username: "jane",
+		Attributes: map[string]interface{}{
+			"created_at": "2024-05-01T12:30:00Z",
+			"age":        float64(42),
+			"nickname":   nil,
+			"tags":       []interface{}{"a", "b"},
+			"address":    map[string]interface{}{"street": "Main St", "zip": int64(12345), "Note": "ignored"},
+		},
+		Traits: map[string]interface{}{
+			"limits": map[string]interface{}{"seats": float64(5)},
+		},
+	}
+	nickname := "jj"
+	c := testCustomer{Email: "kept@example.com", Nickname: &nickname, Plan: "kept"}
+	if err := UnmarshalUser(u, &c); err != nil {
+		t.Fatalf("UnmarshalUser: %v", err)
+	}
+	age := 42
+	want := testCustomer{
+		testAudit: testAudit{CreatedAt: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)},
+		ID:        UID(7),
+		Username:  "jane",
+		Email:     "kept@example.com",
+		Age:       &age,
+		Tags:      []string{"a", "b"},
+		Address:   testAddress{Street: "Main St", Zip: 12345},
+		Plan:      "kept",
+		Limits:    map[string]uint16{"seats": 5},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("UnmarshalUser gave\n%#v\nwant\n%#v", c, want)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	age := 30
+	in := testCustomer{
+		testAudit: testAudit{CreatedAt: time.Date(2023, 1, 2, 3, 4, 5, 6, time.UTC)},
+		ID:        UID(1),
+		Username:  "joe",
+		Email:     "joe@example.com",
+		Age:       &age,
+		Tags:      []string{"x"},
+		Address:   testAddress{Street: "Elm", Zip: 99, City: "Shelbyville"},
+		Plan:      "team",
+		Limits:    map[string]uint16{"seats": 10},
+	}
+	u, err := MarshalUser(in)
+	if err != nil {
+		t.Fatalf("MarshalUser: %v", err)
+	}
+	var out testCustomer
+	if err := UnmarshalUser(u, &out); err != nil {
+		t.Fatalf("UnmarshalUser: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("round trip gave\n%#v\nwant\n%#v", out, in)
+	}
+}
+
+func TestMappingErrors(t *testing.T) {
+	type small struct {
+		N int8 `userup:"attr,n"`
+	}
+	type nested struct {
+		Address testAddress `userup:"attr,address"`
+	}
+	type moment struct {
+		At time.Time `userup:"trait,at"`
+	}
+	type list struct {
+		Scores []uint `userup:"attr,scores"`
+	}
+	tests := []struct {
+		name string
+		user *User
+		v    interface{}
+		path string
+	}{
+		{"overflow", &User{Attributes: map[string]interface{}{"n": int64(300)}}, &small{}, "attributes.n"},
+		{"fraction", &User{Attributes: map[string]interface{}{"n": 1.5}}, &small{}, "attributes.n"},
+		{"string for number", &User{Attributes: map[string]interface{}{"n": "1"}}, &small{}, "attributes.n"},
+		{"nested field", &User{Attributes: map[string]interface{}{"address": map[string]interface{}{"zip": "abc"}}}, &nested{}, "attributes.address.zip"},
+		{"nested not a map", &User{Attributes: map[string]interface{}{"address": "Main St"}}, &nested{}, "attributes.address"},
+		{"bad time", &User{Traits: map[string]interface{}{"at": "yesterday"}}, &moment{}, "traits.at"},
+		{"list item", &User{Attributes: map[string]interface{}{"scores": []interface{}{1, -1}}}, &list{}, "attributes.scores[1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := UnmarshalUser(tt.user, tt.v)
+			var me *MappingError
+			if !errors.As(err, &me) {
+				t.Fatalf("UnmarshalUser error = %v, want a *MappingError", err)
+			}
+			if me.Path != tt.path {
+				t.Errorf("Path = %q, want %q", me.Path, tt.path)
+			}
+		})
+	}
+
+	type badKey struct {
+		M map[int]string `userup:"attr,m"`
+	}
+	_, err := MarshalUser(badKey{M: map[int]string{1: "a"}})
+	var me *MappingError
+	if !errors.As(err, &me) || me.Path != "attributes.m" {
+		t.Errorf("MarshalUser of an int-keyed map: %v, want a *MappingError for attributes.m", err)
+	}
+}
+
+func TestMappingArgumentErrors(t *testing.T) {
+	var c testCustomer
+	var nilCustomer *testCustomer
+	if _, err := MarshalUser(nilCustomer); err == nil {
+		t.Error("MarshalUser of a nil pointer succeeded")
+	}
+	if _, err := MarshalUser(42); err == nil {
+		t.Error("MarshalUser of an int succeeded")
+	}
+	if err := UnmarshalUser(nil, &c); err == nil {
+		t.Error("UnmarshalUser of a nil user succeeded")
+	}
+	if err := UnmarshalUser(&User{}, c); err == nil {
+		t.Error("UnmarshalUser into a non-pointer succeeded")
+	}
+	if err := UnmarshalUser(&User{}, nilCustomer); err == nil {
+		t.Error("UnmarshalUser into a nil pointer succeeded")
+	}
+	n := 1
+	if err := UnmarshalUser(&User{}, &n); err == nil {
+		t.Error("UnmarshalUser into a non-struct succeeded")
+	}
+	type badKind struct {
+		X string `userup:"column,x"`
+	}
+	if _, err := MarshalUser(badKind{}); err == nil {
+		t.Error("MarshalUser with an unknown tag kind succeeded")
+	}
+}
@@ -0,0 +1,198 @@
+package userup
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+// ClientOption configures how NewClient and NewIntegrationsClient connect to
+// the user service.
+type ClientOption func(*clientOptions)
+
+// clientOptions holds the settings collected from a set of ClientOptions.
+type clientOptions struct {
+	dialOptions    []grpc.DialOption
+	block          bool
+	dialTimeout    time.Duration
+	keepalive      *keepalive.ClientParameters
+	userAgent      string
+	maxRecvMsgSize int
+	maxSendMsgSize int
+	tls            *TLSConfig
+
+	perRPCCredentials        credentials.PerRPCCredentials
+	allowInsecureCredentials bool
+
+	interceptors   []grpc.UnaryClientInterceptor
+	retry          *RetryPolicy
+	defaultTimeout time.Duration
+	methodTimeouts map[string]time.Duration
+
+	healthService string
+	healthCheck   bool
+	onStateChange func(connectivity.State)
+
+	loadBalancing *OutlierEjection
+
+	largeIntegers LargeIntegerPolicy
+	conflictRetry *RetryPolicy
+	schema        *Schema
+	schemaMode    SchemaMode
+
+	skipQueryValidation bool
+}
+
+func newClientOptions(opts []ClientOption) clientOptions {
+	var o clientOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithDialOptions appends raw gRPC dial options. They are applied after the
+// options generated by the SDK, so they take precedence when both set the
+// same thing.
+func WithDialOptions(opts ...grpc.DialOption) ClientOption {
+	return func(o *clientOptions) {
+		o.dialOptions = append(o.dialOptions, opts...)
+	}
+}
+
+// WithBlock makes the constructor wait until the connection is up instead of
+// returning immediately and connecting in the background.
+func WithBlock() ClientOption {
+	return func(o *clientOptions) {
+		o.block = true
+	}
+}
+
+// WithDialTimeout makes the constructor block until the connection is up and
+// fail if that takes longer than d.
+func WithDialTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.block = true
+		o.dialTimeout = d
+	}
+}
+
+// WithKeepalive sets the client-side keepalive parameters of the connection.
+func WithKeepalive(params keepalive.ClientParameters) ClientOption {
+	return func(o *clientOptions) {
+		o.keepalive = &params
+	}
+}
+
+// WithUserAgent sets the user agent sent with every request.
+func WithUserAgent(ua string) ClientOption {
+	return func(o *clientOptions) {
+		o.userAgent = ua
+	}
+}
+
+// WithMaxRecvMsgSize sets the largest response message, in bytes, the client
+// will accept.
+func WithMaxRecvMsgSize(n int) ClientOption {
+	return func(o *clientOptions) {
+		o.maxRecvMsgSize = n
+	}
+}
+
+// WithMaxSendMsgSize sets the largest request message, in bytes, the client
+// will send.
+func WithMaxSendMsgSize(n int) ClientOption {
+	return func(o *clientOptions) {
+		o.maxSendMsgSize = n
+	}
+}
+
+// grpcDialOptions turns the collected settings into gRPC dial options.
+func (o clientOptions) grpcDialOptions() ([]grpc.DialOption, error) {
+	creds := insecure.NewCredentials()
+	if o.tls != nil {
+		var err error
+		creds, err = tlsCredentials(*o.tls)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+	}
+	if o.block {
+		dialOpts = append(dialOpts, grpc.WithBlock())
+	}
+	if o.keepalive != nil {
+		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(*o.keepalive))
+	}
+	if o.perRPCCredentials != nil {
+		dialOpts = append(dialOpts, perRPCDialOption(o.perRPCCredentials, o.allowInsecureCredentials))
+	}
+	if o.userAgent != "" {
+		dialOpts = append(dialOpts, grpc.WithUserAgent(o.userAgent))
+	}
+
+	var callOpts []grpc.CallOption
+	if o.maxRecvMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(o.maxRecvMsgSize))
+	}
+	if o.maxSendMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(o.maxSendMsgSize))
+	}
+	if len(callOpts) > 0 {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+
+	retry := DefaultRetryPolicy
+	if o.retry != nil {
+		retry = *o.retry
+	}
+	// User interceptors run outermost so they see a whole call, including
+	// its retries, and the errors the SDK returns.
+	interceptors := append([]grpc.UnaryClientInterceptor{}, o.interceptors...)
+	interceptors = append(interceptors,
+		errorInterceptor,
+		timeoutInterceptor(o.defaultTimeout, o.methodTimeouts),
+		retryInterceptor(retry),
+	)
+	dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(interceptors...))
+
+	return append(dialOpts, o.dialOptions...), nil
+}
+
+// dial establishes a gRPC connection to uri using the given options.
+// uri may also be a comma separated list of addresses.
+func dial(uri string, o clientOptions) (*grpc.ClientConn, error) {
+	target, targetOpts, err := o.resolveTarget(uri)
+	if err != nil {
+		return nil, err
+	}
+	dialOpts, err := o.grpcDialOptions()
+	if err != nil {
+		return nil, err
+	}
+	dialOpts = append(targetOpts, dialOpts...)
+
+	ctx := context.Background()
+	if o.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.dialTimeout)
+		defer cancel()
+	}
+
+	conn, err := grpc.DialContext(ctx, target, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if o.onStateChange != nil {
+		go watchState(conn, o.onStateChange)
+	}
+	return conn, nil
+}
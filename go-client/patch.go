@@ -0,0 +1,263 @@
+package userup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// PatchAction is what a PatchOp does to a field.
+type PatchAction string
+
+const (
+	PatchSet   PatchAction = "set"   // Sets the field to Value.
+	PatchUnset PatchAction = "unset" // Removes the attribute or trait.
+)
+
+// PatchField is the part of a User a PatchOp applies to.
+type PatchField string
+
+const (
+	PatchUsername  PatchField = "username"
+	PatchAttribute PatchField = "attribute"
+	PatchTrait     PatchField = "trait"
+)
+
+// PatchOp is a single change to a user.
+type PatchOp struct {
+	Action PatchAction
+	Field  PatchField
+	Key    string      // Attribute or trait key; empty for the username.
+	Value  interface{} // New value for PatchSet.
+}
+
+func (op PatchOp) String() string {
+	if op.Field == PatchUsername {
+		return fmt.Sprintf("%s %s", op.Action, op.Field)
+	}
+	return fmt.Sprintf("%s %s %q", op.Action, op.Field, op.Key)
+}
+
+// Patch is a list of changes to a user, applied with PatchUser.
+type Patch []PatchOp
+
+// SetUsername returns an operation that changes the username. A username
+// cannot be cleared.
+func SetUsername(name string) PatchOp {
+	return PatchOp{Action: PatchSet, Field: PatchUsername, Value: name}
+}
+
+// SetAttribute returns an operation that sets the attribute key to value.
+func SetAttribute(key string, value interface{}) PatchOp {
+	return PatchOp{Action: PatchSet, Field: PatchAttribute, Key: key, Value: value}
+}
+
+// UnsetAttribute returns an operation that removes the attribute key.
+func UnsetAttribute(key string) PatchOp {
+	return PatchOp{Action: PatchUnset, Field: PatchAttribute, Key: key}
+}
+
+// SetTrait returns an operation that sets the trait key to value.
+func SetTrait(key string, value interface{}) PatchOp {
+	return PatchOp{Action: PatchSet, Field: PatchTrait, Key: key, Value: value}
+}
+
+// UnsetTrait returns an operation that removes the trait key.
+func UnsetTrait(key string) PatchOp {
+	return PatchOp{Action: PatchUnset, Field: PatchTrait, Key: key}
+}
+
+// Diff returns the patch that turns old into new: a set for every attribute
+// or trait that is added or changed, an unset for every one that is removed,
+// and a username change unless the new username is empty. Values are
+// compared as they would be stored, so an int 5 equals a float64 5 read back
+// from the server. Operations are sorted by field and key.
+func Diff(old, new *User) Patch {
+	if old == nil {
+		old = &User{}
+	}
+	if new == nil {
+		new = &User{}
+	}
+
+	var patch Patch
+	if new.Username != "" && new.Username != old.Username {
+		patch = append(patch, SetUsername(new.Username))
+	}
+	patch = append(patch, diffValues(PatchAttribute, old.Attributes, new.Attributes)...)
+	patch = append(patch, diffValues(PatchTrait, old.Traits, new.Traits)...)
+	return patch
+}
+
+func diffValues(field PatchField, old, new map[string]interface{}) Patch {
+	keys := make([]string, 0, len(old)+len(new))
+	for k := range old {
+		keys = append(keys, k)
+	}
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var patch Patch
+	for _, k := range keys {
+		oldVal, inOld := old[k]
+		newVal, inNew := new[k]
+		switch {
+		case !inNew:
+			patch = append(patch, PatchOp{Action: PatchUnset, Field: field, Key: k})
+		case !inOld || !equalValues(oldVal, newVal):
+			patch = append(patch, PatchOp{Action: PatchSet, Field: field, Key: k, Value: newVal})
+		}
+	}
+	return patch
+}
+
+// equalValues reports whether a and b are stored as the same value.
+func equalValues(a, b interface{}) bool {
+	av, errA := LargeIntegersAsStrings.encodeValue(a)
+	bv, errB := LargeIntegersAsStrings.encodeValue(b)
+	if errA != nil || errB != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return proto.Equal(av, bv)
+}
+
+// PatchError is returned by PatchUser when some operations of a patch could
+// not be applied.
+type PatchError struct {
+	Applied []PatchOp // Operations that were applied.
+	Failed  []PatchOp // Operations that failed or were not attempted.
+	Err     error     // The error that stopped the patch.
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("userup: patch stopped after %d of %d operations: %v",
+		len(e.Applied), len(e.Applied)+len(e.Failed), e.Err)
+}
+
+// Unwrap returns the error that stopped the patch.
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// PatchUser applies patch to the user with the given ID. All set operations
+// are sent in a single update; each unset needs a call of its own and is
+// made after it. When an operation appears more than once for the same
+// field and key, the last one wins.
+//
+// An update always carries a username, so when patch sets attributes or
+// traits but not the username, PatchUser first reads the user and sends its
+// current username back unchanged. A rename made by someone else between
+// that read and the update is overwritten.
+//
+// If a call fails, PatchUser stops and returns a *PatchError listing the
+// operations that were and were not applied.
+func (us UserService) PatchUser(ctx context.Context, id UserID, patch Patch) error {
+	if err := requireUserID(userapi.Users_Update_FullMethodName, id); err != nil {
+		return err
+	}
+	sets, unsets, err := splitPatch(patch)
+	if err != nil {
+		return invalidArgument(userapi.Users_Update_FullMethodName, err)
+	}
+
+	var applied []PatchOp
+	fail := func(failed []PatchOp, err error) error {
+		return &PatchError{Applied: applied, Failed: failed, Err: err}
+	}
+
+	if len(sets) > 0 {
+		user := &User{
+			ID:         id,
+			Attributes: map[string]interface{}{},
+			Traits:     map[string]interface{}{},
+		}
+		for _, op := range sets {
+			switch op.Field {
+			case PatchUsername:
+				user.Username = op.Value.(string)
+			case PatchAttribute:
+				user.Attributes[op.Key] = op.Value
+			case PatchTrait:
+				user.Traits[op.Key] = op.Value
+			}
+		}
+		if user.Username == "" {
+			current, err := us.GetUser(ctx, id)
+			if err != nil {
+				return fail(append(sets, unsets...), err)
+			}
+			user.Username = current.Username
+		}
+		if _, err := us.UpdateUser(ctx, user); err != nil {
+			return fail(append(sets, unsets...), err)
+		}
+		applied = append(applied, sets...)
+	}
+
+	for i, op := range unsets {
+		var err error
+		if op.Field == PatchAttribute {
+			err = us.DeleteAttribute(ctx, id, op.Key)
+		} else {
+			err = us.DeleteTrait(ctx, id, op.Key)
+		}
+		if err != nil {
+			return fail(unsets[i:], err)
+		}
+		applied = append(applied, op)
+	}
+	return nil
+}
+
+// splitPatch checks the operations of patch, drops those overridden by a
+// later one, and returns the sets and unsets in their original order.
+func splitPatch(patch Patch) (sets, unsets []PatchOp, err error) {
+	type target struct {
+		field PatchField
+		key   string
+	}
+	last := make(map[target]int, len(patch))
+	for i, op := range patch {
+		switch op.Field {
+		case PatchUsername:
+			if op.Action != PatchSet {
+				return nil, nil, errors.New("a username can only be set")
+			}
+			if name, ok := op.Value.(string); !ok || name == "" {
+				return nil, nil, errors.New("username must be a non-empty string")
+			}
+		case PatchAttribute, PatchTrait:
+			if op.Key == "" {
+				return nil, nil, fmt.Errorf("%s key is required", op.Field)
+			}
+		default:
+			return nil, nil, fmt.Errorf("unknown patch field %q", op.Field)
+		}
+		if op.Action != PatchSet && op.Action != PatchUnset {
+			return nil, nil, fmt.Errorf("unknown patch action %q", op.Action)
+		}
+		last[target{op.Field, op.Key}] = i
+	}
+
+	for i, op := range patch {
+		if last[target{op.Field, op.Key}] != i {
+			continue
+		}
+		if op.Action == PatchSet {
+			sets = append(sets, op)
+		} else {
+			unsets = append(unsets, op)
+		}
+	}
+	return sets, unsets, nil
+}
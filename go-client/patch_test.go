@@ -0,0 +1,88 @@
+package userup
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// patchServer keeps a single user and records the updates it receives.
+// onGet, if set, is called with the lock held before each Get is answered,
+// so tests can change the user between reads.
+type patchServer struct {
+	userapi.UnimplementedUsersServer
+	mu         sync.Mutex
+	username   string
+	attributes map[string]interface{}
+	updates    []*userapi.UserRequest
+	gets       int
+	onGet      func(s *patchServer)
+}
+
+func (s *patchServer) Get(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets++
+	if s.onGet != nil {
+		s.onGet(s)
+	}
+	attrs, err := structpb.NewStruct(s.attributes)
+	if err != nil {
+		return nil, err
+	}
+	return &userapi.UserResponse{Id: req.Id, Username: s.username, Attributes: attrs}, nil
+}
+
+func (s *patchServer) Update(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updates = append(s.updates, req)
+	s.username = req.Username
+	for k, v := range req.Attributes.AsMap() {
+		s.setAttribute(k, v)
+	}
+	return &userapi.UserResponse{Id: req.Id, Username: req.Username}, nil
+}
+
+func (s *patchServer) AddAttribute(ctx context.Context, req *userapi.AttributeRequest) (*userapi.AttributeResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setAttribute(req.Key, req.Value.AsInterface())
+	return &userapi.AttributeResponse{}, nil
+}
+
+func (s *patchServer) setAttribute(key string, value interface{}) {
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+func TestPatchUserKeepsUsername(t *testing.T) {
+	srv := &patchServer{username: "alice"}
+	client := startTestServer(t, srv)
+
+	if err := client.PatchUser(context.Background(), UID(1), Patch{SetAttribute("plan", "pro")}); err != nil {
+		t.Fatalf("PatchUser: %v", err)
+	}
+	if err := client.PatchUser(context.Background(), UID(1), Patch{SetUsername("bob"), SetTrait("seen", true)}); err != nil {
+		t.Fatalf("PatchUser: %v", err)
+	}
+
+	if len(srv.updates) != 2 {
+		t.Fatalf("server got %d updates, want 2", len(srv.updates))
+	}
+	if got := srv.updates[0].Username; got != "alice" {
+		t.Errorf("attribute-only patch sent username %q, want the current alice", got)
+	}
+	if got := srv.updates[0].Attributes.AsMap()["plan"]; got != "pro" {
+		t.Errorf("attribute-only patch sent plan %v, want pro", got)
+	}
+	if got := srv.updates[1].Username; got != "bob" {
+		t.Errorf("rename sent username %q, want bob", got)
+	}
+}
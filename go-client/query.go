@@ -1,5 +1,12 @@
 package userup
 
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
 // LogicalOperator represents a logical operator used in query conditions.
 type LogicalOperator string
 
@@ -10,6 +17,39 @@ type Join struct {
 	Filter map[string]Condition `json:"filter,omitempty"` // Optional filter conditions for the join.
 }
 
+// JoinAttributes returns a join of the attributes table, filtered by filter
+// if it is not nil, e.g. Condition{"alias": "dumbledore"}.
+func JoinAttributes(filter Condition) Join {
+	return newJoin(attributesTable, filter)
+}
+
+// JoinTraits returns a join of the traits table. See JoinAttributes.
+func JoinTraits(filter Condition) Join {
+	return newJoin(traitsTable, filter)
+}
+
+// JoinEvents returns a join of the events table. See JoinAttributes.
+func JoinEvents(filter Condition) Join {
+	return newJoin(eventsTable, filter)
+}
+
+func newJoin(table string, filter Condition) Join {
+	j := Join{Table: table, On: joinOn(table)}
+	if filter != nil {
+		j.Filter = map[string]Condition{table: filter}
+	}
+	return j
+}
+
+// joinOn returns the join condition linking table to the users table.
+func joinOn(table string) string {
+	return fmt.Sprintf("%s.id = %s.user_id", usersTable, table)
+}
+
+// joinOnPattern is the only form of Join.On that is sent to the server:
+// table.column = table.column.
+var joinOnPattern = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)\.[A-Za-z_][A-Za-z0-9_]*\s*=\s*([A-Za-z_][A-Za-z0-9_]*)\.[A-Za-z_][A-Za-z0-9_]*\s*$`)
+
 // Condition represents a condition in a database query.
 type Condition map[string]interface{}
 
@@ -26,5 +66,237 @@ type Query struct {
 // Order specifies the ordering of the query results.
 type Order struct {
 	Field     string `json:"field"`     // The field to order by.
-	Direction string `json:"direction"` // The direction of the ordering ("ASC" or "DESC").
+	Direction string `json:"direction"` // The direction of the ordering ("ASC" or "DESC", in any case).
+}
+
+// joinTables lists the tables a Join can refer to.
+var joinTables = map[string]bool{
+	attributesTable: true,
+	traitsTable:     true,
+	eventsTable:     true,
+}
+
+// operators lists the comparison operators of the query language.
+var operators = map[Operator]bool{
+	OpEq: true, OpNe: true, OpGt: true, OpGte: true, OpLt: true, OpLte: true,
+	OpRegex: true, OpIn: true, OpNin: true, OpLike: true, OpILike: true,
+}
+
+// QueryProblem is one problem found by Query.Validate.
+type QueryProblem struct {
+	Path    string // JSON pointer to the offending part of the query, e.g. "/joins/0/table".
+	Message string
+}
+
+func (p QueryProblem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// QueryError lists the problems found by Query.Validate. Query methods
+// return an *Error matching ErrInvalidArgument that wraps it.
+type QueryError struct {
+	Problems []QueryProblem
+}
+
+func (e *QueryError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		problems[i] = p.String()
+	}
+	return "invalid query: " + strings.Join(problems, "; ")
+}
+
+// Validate checks the query for mistakes the server would reject, such as an
+// unknown operator, an ordering other than ASC or DESC, a negative limit, an
+// $or that is not a list, a join on an unknown table or a join condition
+// other than table.column = table.column. It returns a *QueryError, or nil
+// if no problem was found.
+func (q *Query) Validate() error {
+	if q == nil {
+		return nil
+	}
+	v := &queryValidator{}
+
+	for _, col := range sortedKeys(q.Filter) {
+		path := pointer("filter", col)
+		if strings.HasPrefix(col, "$") {
+			v.add(path, "expected a column name, got operator %s", col)
+			continue
+		}
+		for _, op := range sortedKeys(q.Filter[col]) {
+			v.operator(pointer(path, op), Operator(op), q.Filter[col][op])
+		}
+	}
+	for i, field := range q.Select {
+		if field == "" {
+			v.add(pointer("select", i), "empty field name")
+		}
+	}
+	for i, o := range q.OrderBy {
+		if o.Field == "" {
+			v.add(pointer("order_by", i, "field"), "empty field name")
+		}
+		if d := strings.ToUpper(o.Direction); d != Asc && d != Desc {
+			v.add(pointer("order_by", i, "direction"), "must be %s or %s, got %q", Asc, Desc, o.Direction)
+		}
+	}
+	if q.Limit < 0 {
+		v.add("/limit", "must not be negative, got %d", q.Limit)
+	}
+	if q.Offset < 0 {
+		v.add("/offset", "must not be negative, got %d", q.Offset)
+	}
+	for i, j := range q.Joins {
+		path := pointer("joins", i)
+		if !joinTables[j.Table] {
+			v.add(pointer(path, "table"), "unknown table %q, expected one of %s", j.Table, strings.Join(sortedKeys(joinTables), ", "))
+		}
+		v.joinOn(pointer(path, "on"), j.On)
+		for _, table := range sortedKeys(j.Filter) {
+			v.joinCondition(pointer(path, "filter", table), j.Filter[table], true)
+		}
+	}
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &QueryError{Problems: v.problems}
+}
+
+// validateJoinOn checks only the On conditions of the joins of q. They are
+// sent to the server as written, so unlike the rest of Validate this check
+// cannot be turned off.
+func (q *Query) validateJoinOn() error {
+	v := &queryValidator{}
+	for i, j := range q.Joins {
+		v.joinOn(pointer("joins", i, "on"), j.On)
+	}
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &QueryError{Problems: v.problems}
+}
+
+type queryValidator struct {
+	problems []QueryProblem
+}
+
+func (v *queryValidator) add(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, QueryProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// joinOn checks that on has the form table.column = table.column and only
+// refers to tables a query can use.
+func (v *queryValidator) joinOn(path, on string) {
+	m := joinOnPattern.FindStringSubmatch(on)
+	if m == nil {
+		v.add(path, "must have the form table.column = table.column, got %q", on)
+		return
+	}
+	for _, table := range m[1:] {
+		if table != usersTable && !joinTables[table] {
+			v.add(path, "unknown table %q", table)
+		}
+	}
+}
+
+// joinCondition checks the filter of a join: keys are compared with plain
+// values or operator maps, and $or holds a list of such conditions.
+func (v *queryValidator) joinCondition(path string, cond map[string]interface{}, allowOr bool) {
+	for _, key := range sortedKeys(cond) {
+		keyPath := pointer(path, key)
+		value := cond[key]
+		switch {
+		case key == "$or" && allowOr:
+			branches, ok := asList(value)
+			if !ok {
+				v.add(keyPath, "$or must be a list of conditions, got %T", value)
+				continue
+			}
+			for i, branch := range branches {
+				m, ok := asMap(branch)
+				if !ok {
+					v.add(pointer(keyPath, i), "expected a condition, got %T", branch)
+					continue
+				}
+				v.joinCondition(pointer(keyPath, i), m, false)
+			}
+		case strings.HasPrefix(key, "$"):
+			v.add(keyPath, "unexpected operator %s", key)
+		default:
+			if ops, ok := asMap(value); ok {
+				for _, op := range sortedKeys(ops) {
+					v.operator(pointer(keyPath, op), Operator(op), ops[op])
+				}
+			}
+		}
+	}
+}
+
+// operator checks that op is known and value suits it.
+func (v *queryValidator) operator(path string, op Operator, value interface{}) {
+	if !operators[op] {
+		v.add(path, "unknown operator %q", op)
+		return
+	}
+	switch op {
+	case OpIn, OpNin:
+		if _, ok := asList(value); !ok {
+			v.add(path, "%s needs a list, got %T", op, value)
+		}
+	case OpRegex, OpLike, OpILike:
+		if _, ok := value.(string); !ok {
+			v.add(path, "%s needs a string, got %T", op, value)
+		}
+	}
+}
+
+func asList(v interface{}) ([]interface{}, bool) {
+	rv := reflect.ValueOf(v)
+	if v == nil || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) {
+		return nil, false
+	}
+	list := make([]interface{}, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, true
+}
+
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case Condition:
+		return m, true
+	case map[string]interface{}:
+		return m, true
+	}
+	return nil, false
+}
+
+// pointer joins path elements into a JSON pointer, escaping them as RFC 6901
+// requires. A first element starting with "/" is taken as an existing
+// pointer.
+func pointer(base interface{}, elems ...interface{}) string {
+	var b strings.Builder
+	for i, e := range append([]interface{}{base}, elems...) {
+		s := fmt.Sprint(e)
+		if i == 0 && strings.HasPrefix(s, "/") {
+			b.WriteString(s)
+			continue
+		}
+		b.WriteString("/")
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(s))
+	}
+	return b.String()
+}
+
+// WithoutQueryValidation turns off the Query.Validate check QueryUsers,
+// QueryAttributes, QueryTraits and QueryEvents make before sending a query,
+// for queries using features the SDK does not know about yet. Join
+// conditions are still required to have the form table.column =
+// table.column over known tables.
+func WithoutQueryValidation() ClientOption {
+	return func(o *clientOptions) {
+		o.skipQueryValidation = true
+	}
 }
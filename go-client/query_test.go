@@ -0,0 +1,88 @@
+package userup
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+func TestValidateReportsProblemPaths(t *testing.T) {
+	q := &Query{
+		Filter:  map[string]Condition{"username": {"$eqq": "x"}, "a/b": {"$in": "x"}},
+		OrderBy: []Order{{Field: "id", Direction: "down"}},
+		Limit:   -1,
+		Joins: []Join{
+			{Table: "atributes", On: joinOn(attributesTable)},
+			{Table: traitsTable, On: "1=1", Filter: map[string]Condition{traitsTable: {"$or": "x"}}},
+		},
+	}
+	var qe *QueryError
+	if err := q.Validate(); !errors.As(err, &qe) {
+		t.Fatalf("Validate() = %v, want a *QueryError", err)
+	}
+	got := map[string]bool{}
+	for _, p := range qe.Problems {
+		got[p.Path] = true
+	}
+	for _, path := range []string{
+		"/filter/username/$eqq",
+		"/filter/a~1b/$in",
+		"/order_by/0/direction",
+		"/limit",
+		"/joins/0/table",
+		"/joins/1/on",
+		"/joins/1/filter/traits/$or",
+	} {
+		if !got[path] {
+			t.Errorf("no problem reported at %s; got %v", path, qe.Problems)
+		}
+	}
+}
+
+func TestEncodeQuery(t *testing.T) {
+	const method = userapi.Users_QueryUsers_FullMethodName
+	for _, us := range []UserService{{}, {opts: clientOptions{skipQueryValidation: true}}} {
+		if _, err := us.encodeQuery(method, nil); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("encodeQuery(nil) = %v, want ErrInvalidArgument", err)
+		}
+
+		query := &Query{OrderBy: []Order{{Field: "id", Direction: "desc"}}}
+		data, err := us.encodeQuery(method, query)
+		if err != nil {
+			t.Fatalf("encodeQuery: %v", err)
+		}
+		var sent Query
+		if err := json.Unmarshal(data, &sent); err != nil {
+			t.Fatal(err)
+		}
+		if got := sent.OrderBy[0].Direction; got != Desc {
+			t.Errorf("direction sent as %q, want %q", got, Desc)
+		}
+		if query.OrderBy[0].Direction != "desc" {
+			t.Error("encodeQuery modified the caller's query")
+		}
+	}
+}
+
+func TestEncodeQueryAlwaysChecksJoinOn(t *testing.T) {
+	us := UserService{opts: clientOptions{skipQueryValidation: true}}
+	for _, on := range []string{
+		"users.id = attributes.user_id; DROP TABLE users",
+		"1 = 1",
+		"users.id = secrets.user_id",
+		"",
+	} {
+		query := &Query{Joins: []Join{{Table: attributesTable, On: on}}}
+		if _, err := us.encodeQuery(userapi.Users_QueryUsers_FullMethodName, query); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("On %q: encodeQuery = %v, want ErrInvalidArgument", on, err)
+		}
+	}
+
+	// Other checks are skipped.
+	query := &Query{Joins: []Join{{Table: "custom", On: "users.id = events.user_id"}}}
+	if _, err := us.encodeQuery(userapi.Users_QueryUsers_FullMethodName, query); err != nil {
+		t.Errorf("encodeQuery without validation: %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package userup
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Operator is a comparison operator of the query language.
+type Operator string
+
+const (
+	OpEq    Operator = "$eq"    // Equal.
+	OpNe    Operator = "$ne"    // Not equal.
+	OpGt    Operator = "$gt"    // Greater than.
+	OpGte   Operator = "$gte"   // Greater than or equal to.
+	OpLt    Operator = "$lt"    // Less than.
+	OpLte   Operator = "$lte"   // Less than or equal to.
+	OpRegex Operator = "$regex" // Matches a regular expression.
+	OpIn    Operator = "$in"    // Equal to one of a list of values.
+	OpNin   Operator = "$nin"   // Equal to none of a list of values.
+	OpLike  Operator = "$like"  // SQL LIKE pattern.
+	OpILike Operator = "$ilike" // Case insensitive SQL LIKE pattern.
+)
+
+// Orderings for OrderBy.
+const (
+	Asc  = "ASC"
+	Desc = "DESC"
+)
+
+// Tables a query can filter on.
+const (
+	usersTable      = "users"
+	attributesTable = "attributes"
+	traitsTable     = "traits"
+	eventsTable     = "events"
+)
+
+// Field is something a query can filter on: a column of the users table, an
+// attribute, a trait or an event field.
+type Field struct {
+	table string
+	name  string
+}
+
+// Column returns the column of the users table with the given name, such as
+// "id" or "username".
+func Column(name string) Field {
+	return Field{table: usersTable, name: name}
+}
+
+// AttrField returns the attribute with the given key.
+func AttrField(key string) Field {
+	return Field{table: attributesTable, name: key}
+}
+
+// TraitField returns the trait with the given key.
+func TraitField(key string) Field {
+	return Field{table: traitsTable, name: key}
+}
+
+// EventField returns the field of the events table with the given name,
+// such as "type" or "source".
+func EventField(name string) Field {
+	return Field{table: eventsTable, name: name}
+}
+
+func (f Field) String() string {
+	return f.table + "." + f.name
+}
+
+// Predicate is a condition on a Field, or a combination of them built with
+// Or or And.
+type Predicate struct {
+	field Field
+	op    Operator
+	value interface{}
+	or    []Predicate
+	and   []Predicate
+}
+
+func (f Field) is(op Operator, value interface{}) Predicate {
+	return Predicate{field: f, op: op, value: value}
+}
+
+func (f Field) Eq(value interface{}) Predicate      { return f.is(OpEq, value) }
+func (f Field) Ne(value interface{}) Predicate      { return f.is(OpNe, value) }
+func (f Field) Gt(value interface{}) Predicate      { return f.is(OpGt, value) }
+func (f Field) Gte(value interface{}) Predicate     { return f.is(OpGte, value) }
+func (f Field) Lt(value interface{}) Predicate      { return f.is(OpLt, value) }
+func (f Field) Lte(value interface{}) Predicate     { return f.is(OpLte, value) }
+func (f Field) Regex(pattern string) Predicate      { return f.is(OpRegex, pattern) }
+func (f Field) In(values ...interface{}) Predicate  { return f.is(OpIn, values) }
+func (f Field) Nin(values ...interface{}) Predicate { return f.is(OpNin, values) }
+func (f Field) Like(pattern string) Predicate       { return f.is(OpLike, pattern) }
+func (f Field) ILike(pattern string) Predicate      { return f.is(OpILike, pattern) }
+
+// Or matches when any of preds does. The query language only supports Or
+// between conditions on the same one of attributes, traits or events.
+func Or(preds ...Predicate) Predicate {
+	return Predicate{or: append([]Predicate{}, preds...)}
+}
+
+// And matches when all of preds do. Conditions passed to QueryBuilder.And
+// must all hold anyway; And is for grouping conditions inside an Or, as in
+// Or(And(a, b), c).
+func And(preds ...Predicate) Predicate {
+	return Predicate{and: append([]Predicate{}, preds...)}
+}
+
+// flattenAnd replaces the And predicates in preds by their conditions.
+func flattenAnd(preds []Predicate) []Predicate {
+	var flat []Predicate
+	for _, p := range preds {
+		if p.and != nil {
+			flat = append(flat, flattenAnd(p.and)...)
+		} else {
+			flat = append(flat, p)
+		}
+	}
+	return flat
+}
+
+// QueryBuilder builds a Query. Start one with NewQuery, add conditions with
+// Where and And, and finish with Build. All conditions must hold.
+type QueryBuilder struct {
+	preds   []Predicate
+	selects []string
+	orderBy []Order
+	limit   int
+	offset  int
+	errs    []error
+}
+
+// NewQuery starts a new query.
+func NewQuery() *QueryBuilder {
+	return &QueryBuilder{}
+}
+
+// Where starts a condition on a column of the users table. Finish it with an
+// operator method such as Eq or ILike.
+func (b *QueryBuilder) Where(column string) WhereClause {
+	return WhereClause{b: b, field: Column(column)}
+}
+
+// And adds conditions that must hold.
+func (b *QueryBuilder) And(preds ...Predicate) *QueryBuilder {
+	b.preds = append(b.preds, preds...)
+	return b
+}
+
+// Select limits the fields returned.
+func (b *QueryBuilder) Select(fields ...string) *QueryBuilder {
+	b.selects = append(b.selects, fields...)
+	return b
+}
+
+// OrderBy sorts the results by field in the direction Asc or Desc. It can be
+// called more than once to sort by several fields.
+func (b *QueryBuilder) OrderBy(field, direction string) *QueryBuilder {
+	switch strings.ToUpper(direction) {
+	case Asc, Desc:
+		b.orderBy = append(b.orderBy, Order{Field: field, Direction: strings.ToUpper(direction)})
+	default:
+		b.errs = append(b.errs, fmt.Errorf("order by %s: direction must be %s or %s, got %q", field, Asc, Desc, direction))
+	}
+	return b
+}
+
+// Limit sets the maximum number of results.
+func (b *QueryBuilder) Limit(n int) *QueryBuilder {
+	b.limit = n
+	return b
+}
+
+// Offset skips the first n results.
+func (b *QueryBuilder) Offset(n int) *QueryBuilder {
+	b.offset = n
+	return b
+}
+
+// Build returns the Query. It fails if a condition cannot be expressed in the
+// query language, such as an Or that mixes attributes and traits.
+func (b *QueryBuilder) Build() (*Query, error) {
+	q, errs := b.build()
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("userup: invalid query: %w", err)
+	}
+	return q, nil
+}
+
+// build returns the Query and every problem found building it.
+func (b *QueryBuilder) build() (*Query, []error) {
+	errs := append([]error(nil), b.errs...)
+	q := &Query{
+		Select:  b.selects,
+		OrderBy: b.orderBy,
+		Limit:   b.limit,
+		Offset:  b.offset,
+	}
+
+	// Conditions on attributes and traits go into a join of their table.
+	joins := map[string]Condition{}
+	var joinOrder []string
+	joinFilter := func(table string) Condition {
+		cond, ok := joins[table]
+		if !ok {
+			cond = Condition{}
+			joins[table] = cond
+			joinOrder = append(joinOrder, table)
+		}
+		return cond
+	}
+
+	for _, p := range flattenAnd(b.preds) {
+		if p.or != nil {
+			table, branches, err := p.orBranches()
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			cond := joinFilter(table)
+			if _, ok := cond["$or"]; ok {
+				errs = append(errs, fmt.Errorf("only one Or per table is supported, %s has more", table))
+				continue
+			}
+			cond["$or"] = branches
+			continue
+		}
+		if err := p.check(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if p.field.table == usersTable {
+			if q.Filter == nil {
+				q.Filter = map[string]Condition{}
+			}
+			cond, ok := q.Filter[p.field.name]
+			if !ok {
+				cond = Condition{}
+				q.Filter[p.field.name] = cond
+			}
+			if err := addOperator(cond, p); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if err := addJoinCondition(joinFilter(p.field.table), p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, table := range joinOrder {
+		q.Joins = append(q.Joins, newJoin(table, joins[table]))
+	}
+
+	return q, errs
+}
+
+func (p Predicate) check() error {
+	if p.field.name == "" {
+		return errors.New("condition on an empty field name")
+	}
+	return nil
+}
+
+// orBranches returns the table the branches of an Or apply to and their
+// conditions.
+func (p Predicate) orBranches() (string, []Condition, error) {
+	if len(p.or) == 0 {
+		return "", nil, errors.New("Or needs at least one condition")
+	}
+	var table string
+	branches := make([]Condition, 0, len(p.or))
+	for _, branch := range p.or {
+		cond := Condition{}
+		for _, c := range flattenAnd([]Predicate{branch}) {
+			if c.or != nil {
+				return "", nil, errors.New("nested Or is not supported")
+			}
+			if err := c.check(); err != nil {
+				return "", nil, err
+			}
+			if c.field.table == usersTable {
+				return "", nil, fmt.Errorf("Or on %s: only conditions on one of attributes, traits or events can be combined with Or", c.field)
+			}
+			if table == "" {
+				table = c.field.table
+			}
+			if c.field.table != table {
+				return "", nil, fmt.Errorf("Or mixes %s and %s; all conditions must be on the same table", table, c.field.table)
+			}
+			if err := addJoinCondition(cond, c); err != nil {
+				return "", nil, err
+			}
+		}
+		if len(cond) == 0 {
+			return "", nil, errors.New("Or has an empty branch")
+		}
+		branches = append(branches, cond)
+	}
+	return table, branches, nil
+}
+
+// addOperator adds the operator of p to the condition on a users column.
+func addOperator(cond Condition, p Predicate) error {
+	if _, ok := cond[string(p.op)]; ok {
+		return fmt.Errorf("%s has more than one %s condition", p.field, p.op)
+	}
+	cond[string(p.op)] = p.value
+	return nil
+}
+
+// addJoinCondition adds p to the filter of an attributes or traits join.
+// Equality is written as a plain value, other operators as an operator map.
+func addJoinCondition(cond Condition, p Predicate) error {
+	existing, ok := cond[p.field.name]
+	if p.op == OpEq {
+		if ok {
+			return fmt.Errorf("%s has more than one condition with %s", p.field, OpEq)
+		}
+		cond[p.field.name] = p.value
+		return nil
+	}
+
+	if !ok {
+		cond[p.field.name] = Condition{string(p.op): p.value}
+		return nil
+	}
+	ops, isOps := existing.(Condition)
+	if !isOps {
+		return fmt.Errorf("%s has more than one condition with %s", p.field, OpEq)
+	}
+	return addOperator(ops, p)
+}
+
+// WhereClause is a condition on a users column started by
+// QueryBuilder.Where. Its methods complete the condition and return the
+// builder.
+type WhereClause struct {
+	b     *QueryBuilder
+	field Field
+}
+
+func (w WhereClause) Eq(value interface{}) *QueryBuilder      { return w.b.And(w.field.Eq(value)) }
+func (w WhereClause) Ne(value interface{}) *QueryBuilder      { return w.b.And(w.field.Ne(value)) }
+func (w WhereClause) Gt(value interface{}) *QueryBuilder      { return w.b.And(w.field.Gt(value)) }
+func (w WhereClause) Gte(value interface{}) *QueryBuilder     { return w.b.And(w.field.Gte(value)) }
+func (w WhereClause) Lt(value interface{}) *QueryBuilder      { return w.b.And(w.field.Lt(value)) }
+func (w WhereClause) Lte(value interface{}) *QueryBuilder     { return w.b.And(w.field.Lte(value)) }
+func (w WhereClause) Regex(pattern string) *QueryBuilder      { return w.b.And(w.field.Regex(pattern)) }
+func (w WhereClause) In(values ...interface{}) *QueryBuilder  { return w.b.And(w.field.In(values...)) }
+func (w WhereClause) Nin(values ...interface{}) *QueryBuilder { return w.b.And(w.field.Nin(values...)) }
+func (w WhereClause) Like(pattern string) *QueryBuilder       { return w.b.And(w.field.Like(pattern)) }
+func (w WhereClause) ILike(pattern string) *QueryBuilder      { return w.b.And(w.field.ILike(pattern)) }
@@ -0,0 +1,177 @@
+package userup
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// compactJSON strips the layout from a golden JSON document.
+func compactJSON(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(s)); err != nil {
+		t.Fatalf("bad golden JSON %s: %v", s, err)
+	}
+	return buf.String()
+}
+
+func TestQueryBuilderOperators(t *testing.T) {
+	tests := []struct {
+		b    *QueryBuilder
+		want string
+	}{
+		{NewQuery().Where("username").Eq("jane"), `{"$eq": "jane"}`},
+		{NewQuery().Where("username").Ne("jane"), `{"$ne": "jane"}`},
+		{NewQuery().Where("username").Gt(1), `{"$gt": 1}`},
+		{NewQuery().Where("username").Gte(1), `{"$gte": 1}`},
+		{NewQuery().Where("username").Lt(1), `{"$lt": 1}`},
+		{NewQuery().Where("username").Lte(1), `{"$lte": 1}`},
+		{NewQuery().Where("username").Regex("^j"), `{"$regex": "^j"}`},
+		{NewQuery().Where("username").In("a", "b"), `{"$in": ["a", "b"]}`},
+		{NewQuery().Where("username").Nin(1, 2), `{"$nin": [1, 2]}`},
+		{NewQuery().Where("username").Like("j%"), `{"$like": "j%"}`},
+		{NewQuery().Where("username").ILike("J%"), `{"$ilike": "J%"}`},
+		{NewQuery().Where("username").Gte("a").Where("username").Lt("b"), `{"$gte": "a", "$lt": "b"}`},
+	}
+	for _, tt := range tests {
+		want := compactJSON(t, `{"filter": {"username": `+tt.want+`}}`)
+		q, err := tt.b.Build()
+		if err != nil {
+			t.Errorf("%s: Build: %v", want, err)
+			continue
+		}
+		got, err := json.Marshal(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("Build gave\n%s\nwant\n%s", got, want)
+		}
+	}
+}
+
+func TestQueryBuilderGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *QueryBuilder
+		want string
+	}{
+		{"empty", NewQuery(), `{"filter": null}`},
+		{
+			"select, order and paging",
+			NewQuery().Select("id", "username").OrderBy("id", "desc").OrderBy("username", Asc).Limit(10).Offset(20),
+			`{"filter": null, "select": ["id", "username"],
+			  "order_by": [{"field": "id", "direction": "DESC"}, {"field": "username", "direction": "ASC"}],
+			  "limit": 10, "offset": 20}`,
+		},
+		{
+			"joins merge conditions per table in order of first use",
+			NewQuery().
+				And(TraitField("plan").Eq("pro")).
+				And(AttrField("level").Gte(2), AttrField("level").Lt(5)).
+				And(TraitField("seats").Gt(1)).
+				And(AttrField("email").Like("%@example.com")),
+			`{"filter": null, "joins": [
+			  {"table": "traits", "on": "users.id = traits.user_id",
+			   "filter": {"traits": {"plan": "pro", "seats": {"$gt": 1}}}},
+			  {"table": "attributes", "on": "users.id = attributes.user_id",
+			   "filter": {"attributes": {"email": {"$like": "%@example.com"}, "level": {"$gte": 2, "$lt": 5}}}}
+			]}`,
+		},
+		{
+			"users columns and joins together",
+			NewQuery().Where("username").ILike("j%").And(EventField("type").Eq("login"), And(Column("id").Gt(3))),
+			`{"filter": {"id": {"$gt": 3}, "username": {"$ilike": "j%"}}, "joins": [
+			  {"table": "events", "on": "users.id = events.user_id", "filter": {"events": {"type": "login"}}}
+			]}`,
+		},
+		{
+			"or branches",
+			NewQuery().
+				And(AttrField("vip").Eq(true)).
+				And(Or(
+					TraitField("plan").Eq("pro"),
+					And(TraitField("plan").Eq("team"), TraitField("seats").Gte(10)),
+				)),
+			`{"filter": null, "joins": [
+			  {"table": "attributes", "on": "users.id = attributes.user_id", "filter": {"attributes": {"vip": true}}},
+			  {"table": "traits", "on": "users.id = traits.user_id", "filter": {"traits": {"$or": [
+			    {"plan": "pro"},
+			    {"plan": "team", "seats": {"$gte": 10}}
+			  ]}}}
+			]}`,
+		},
+		{
+			"or next to plain conditions on the same table",
+			NewQuery().And(TraitField("active").Eq(true), Or(TraitField("plan").Eq("pro"), TraitField("trial").Eq(true))),
+			`{"filter": null, "joins": [
+			  {"table": "traits", "on": "users.id = traits.user_id", "filter": {"traits": {
+			    "$or": [{"plan": "pro"}, {"trial": true}], "active": true
+			  }}}
+			]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := tt.b.Build()
+			if err != nil {
+				t.Fatalf("Build: %v", err)
+			}
+			got, err := json.Marshal(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := compactJSON(t, tt.want); string(got) != want {
+				t.Errorf("Build gave\n%s\nwant\n%s", got, want)
+			}
+			if err := q.Validate(); err != nil {
+				t.Errorf("built query does not validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestQueryBuilderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *QueryBuilder
+		msg  string
+	}{
+		{"or mixing tables", NewQuery().And(Or(AttrField("a").Eq(1), TraitField("b").Eq(2))), "Or mixes attributes and traits"},
+		{"or on a users column", NewQuery().And(Or(Column("username").Eq("x"), TraitField("b").Eq(2))), "Or on users.username"},
+		{"second or on a table", NewQuery().And(
+			Or(TraitField("a").Eq(1), TraitField("b").Eq(2)),
+			Or(TraitField("c").Eq(3), TraitField("d").Eq(4)),
+		), "only one Or per table"},
+		{"nested or", NewQuery().And(Or(TraitField("a").Eq(1), And(TraitField("b").Eq(2), Or(TraitField("c").Eq(3))))), "nested Or"},
+		{"empty or", NewQuery().And(Or()), "at least one condition"},
+		{"empty or branch", NewQuery().And(Or(TraitField("a").Eq(1), And())), "empty branch"},
+		{"duplicate $eq on a column", NewQuery().Where("username").Eq("a").Where("username").Eq("b"), "users.username has more than one $eq"},
+		{"duplicate $eq on an attribute", NewQuery().And(AttrField("a").Eq(1), AttrField("a").Eq(2)), "attributes.a has more than one condition with $eq"},
+		{"operator after $eq", NewQuery().And(AttrField("a").Eq(1), AttrField("a").Gt(0)), "attributes.a has more than one condition with $eq"},
+		{"duplicate operator in a join", NewQuery().And(AttrField("a").Gt(1), AttrField("a").Gt(2)), "attributes.a has more than one $gt"},
+		{"empty field name", NewQuery().And(AttrField("").Eq(1)), "empty field name"},
+		{"bad direction", NewQuery().OrderBy("id", "sideways"), `direction must be ASC or DESC, got "sideways"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := tt.b.Build()
+			if err == nil {
+				t.Fatalf("Build = %+v, want an error", q)
+			}
+			if !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("Build error %q does not mention %q", err, tt.msg)
+			}
+		})
+	}
+
+	// Every problem is reported, not just the first.
+	_, err := NewQuery().OrderBy("id", "up").And(AttrField("").Eq(1), Or()).Build()
+	for _, msg := range []string{"direction", "empty field name", "at least one condition"} {
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("Build error %v does not mention %q", err, msg)
+		}
+	}
+}
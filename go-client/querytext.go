@@ -0,0 +1,892 @@
+package userup
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseQuery parses a query written in the text query language, such as
+//
+//	attributes.vip_level >= 2 and username ilike 'j%'
+//	order by id desc limit 20
+//
+// Conditions compare a field with a value using =, != (or <>), <, <=, >,
+// >=, like, ilike, regex, in (...) or not in (...), and are combined with
+// and, or and parentheses. A field is a column of the users table, such as
+// username, or an attribute, trait or event field written as
+// attributes.key, traits.key or events.key; conditions on the latter add the
+// join for their table. Names that are not plain identifiers are written in
+// double quotes, as in attributes."2fa". Values are numbers, 'single quoted'
+// strings, true, false or null.
+//
+// An optional "select field, ..." prefix sets Query.Select and is followed
+// by where when there are conditions. Order by, limit and offset clauses may
+// follow the conditions. Keywords are case
+// insensitive.
+//
+// Or can only combine conditions on the same one of attributes, traits or
+// events, as in Query itself, so "username = 'x' or traits.plan = 'pro'" is
+// rejected; ParseQueries accepts it by splitting it into several queries.
+// Errors are returned as a *ParseError giving the line and column of the
+// problem.
+func ParseQuery(text string) (*Query, error) {
+	queries, err := parseQueries(text, false)
+	if err != nil {
+		return nil, err
+	}
+	return queries[0], nil
+}
+
+// MaxQueryUnion is the largest number of queries ParseQueries splits a text
+// query into.
+const MaxQueryUnion = 16
+
+// ParseQueries parses a text query as ParseQuery does, but also accepts an
+// or that a single Query cannot express, such as
+//
+//	attributes.vip_level >= 2 and (username ilike 'j%' or traits.plan in ('pro','team'))
+//
+// It returns a union of queries: a user matches the text if it matches any
+// of them. Only the conditions that need it are split, so a text ParseQuery
+// accepts gives a single query, and the example above gives two, one with
+// each side of the or. Every query carries the select, order by, limit and
+// offset of the text; run them with QueryUsersUnion, which applies those to
+// the merged results. A text that would need more than MaxQueryUnion queries
+// is rejected.
+func ParseQueries(text string) ([]*Query, error) {
+	return parseQueries(text, true)
+}
+
+func parseQueries(text string, split bool) ([]*Query, error) {
+	p := &queryParser{lex: newQueryLexer(text), split: split}
+	p.next()
+	return p.parse()
+}
+
+// ParseError is a syntax error, or a condition the Query format cannot
+// express, in a text query.
+type ParseError struct {
+	Line   int // 1-based line of the problem.
+	Column int // 1-based column of the problem, in runes.
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("userup: query:%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// FormatQuery renders q in the text query language read by ParseQuery. It
+// fails for queries the language cannot express, such as a join with a
+// custom On condition.
+func FormatQuery(q *Query) (string, error) {
+	f := &queryFormatter{}
+	return f.format(q)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent
+	tokNumber
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind      tokenKind
+	text      string
+	line, col int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokString:
+		return "string " + quoteString(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// is reports whether t is the keyword or punctuation s.
+func (t token) is(s string) bool {
+	switch t.kind {
+	case tokIdent:
+		return strings.EqualFold(t.text, s)
+	case tokPunct:
+		return t.text == s
+	}
+	return false
+}
+
+type queryLexer struct {
+	src       []rune
+	pos       int
+	line, col int
+}
+
+func newQueryLexer(text string) *queryLexer {
+	return &queryLexer{src: []rune(text), line: 1, col: 1}
+}
+
+func (l *queryLexer) peek(offset int) rune {
+	if l.pos+offset >= len(l.src) {
+		return 0
+	}
+	return l.src[l.pos+offset]
+}
+
+func (l *queryLexer) advance() rune {
+	r := l.src[l.pos]
+	l.pos++
+	if r == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return r
+}
+
+func (l *queryLexer) errorf(line, col int, format string, args ...interface{}) error {
+	return &ParseError{Line: line, Column: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
+
+func (l *queryLexer) token() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(l.peek(0)) {
+		l.advance()
+	}
+	t := token{line: l.line, col: l.col}
+	if l.pos >= len(l.src) {
+		return t, nil
+	}
+
+	r := l.peek(0)
+	switch {
+	case isIdentStart(r):
+		var b strings.Builder
+		for l.pos < len(l.src) && isIdentPart(l.peek(0)) {
+			b.WriteRune(l.advance())
+		}
+		t.kind, t.text = tokIdent, b.String()
+
+	case unicode.IsDigit(r) || (r == '-' && unicode.IsDigit(l.peek(1))):
+		var b strings.Builder
+		b.WriteRune(l.advance())
+		for l.pos < len(l.src) {
+			c := l.peek(0)
+			if unicode.IsDigit(c) || c == '.' || c == 'e' || c == 'E' ||
+				((c == '+' || c == '-') && (l.src[l.pos-1] == 'e' || l.src[l.pos-1] == 'E')) {
+				b.WriteRune(l.advance())
+				continue
+			}
+			break
+		}
+		if l.pos < len(l.src) && isIdentStart(l.peek(0)) {
+			return t, l.errorf(t.line, t.col, "invalid number %s%c; quote names that start with a digit", b.String(), l.peek(0))
+		}
+		t.kind, t.text = tokNumber, b.String()
+
+	case r == '\'' || r == '"':
+		quote := l.advance()
+		var b strings.Builder
+		for {
+			if l.pos >= len(l.src) {
+				return t, l.errorf(t.line, t.col, "unterminated %c", quote)
+			}
+			c := l.advance()
+			if c == quote {
+				if l.peek(0) != quote {
+					break
+				}
+				l.advance()
+			}
+			b.WriteRune(c)
+		}
+		t.kind, t.text = tokString, b.String()
+		if quote == '"' {
+			t.kind = tokQuotedIdent
+		}
+
+	default:
+		l.advance()
+		t.kind, t.text = tokPunct, string(r)
+		two := string(r) + string(l.peek(0))
+		switch two {
+		case "!=", "<>", "<=", ">=":
+			l.advance()
+			t.text = two
+		}
+		if !strings.Contains("(),.=<>~", t.text) && len(t.text) == 1 {
+			return t, l.errorf(t.line, t.col, "unexpected character %q", r)
+		}
+	}
+	return t, nil
+}
+
+// condNode is a parsed condition: a comparison, or an and/or of conditions.
+type condNode struct {
+	op        string // "cmp", "and" or "or".
+	pred      Predicate
+	children  []*condNode
+	line, col int
+}
+
+type queryParser struct {
+	lex   *queryLexer
+	tok   token
+	err   error
+	split bool // Split conditions a single Query cannot express into a union.
+}
+
+func (p *queryParser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.token()
+}
+
+func (p *queryParser) fail(t token, format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = &ParseError{Line: t.line, Column: t.col, Msg: fmt.Sprintf(format, args...)}
+	}
+}
+
+func (p *queryParser) expect(s string) {
+	if !p.tok.is(s) {
+		p.fail(p.tok, "expected %s, found %s", s, p.tok)
+		return
+	}
+	p.next()
+}
+
+func (p *queryParser) parse() ([]*Query, error) {
+	b := NewQuery()
+
+	conditions := !p.atClauseEnd()
+	if p.tok.is("select") {
+		p.next()
+		for {
+			b.Select(p.name())
+			if !p.tok.is(",") {
+				break
+			}
+			p.next()
+		}
+		conditions = false
+		if p.tok.is("where") {
+			p.next()
+			conditions = true
+		} else if !p.atClauseEnd() {
+			p.fail(p.tok, "expected where, found %s", p.tok)
+		}
+	} else if p.tok.is("where") {
+		p.next()
+	}
+
+	var root *condNode
+	if conditions && p.err == nil {
+		root = p.orExpr()
+	}
+
+	if p.tok.is("order") {
+		p.next()
+		p.expect("by")
+		for p.err == nil {
+			name := p.name()
+			dir := Asc
+			if p.tok.is("asc") || p.tok.is("desc") {
+				dir = strings.ToUpper(p.tok.text)
+				p.next()
+			}
+			b.OrderBy(name, dir)
+			if !p.tok.is(",") {
+				break
+			}
+			p.next()
+		}
+	}
+	if p.tok.is("limit") {
+		p.next()
+		b.Limit(p.count("limit"))
+	}
+	if p.tok.is("offset") {
+		p.next()
+		b.Offset(p.count("offset"))
+	}
+	if p.err == nil && p.tok.kind != tokEOF {
+		p.fail(p.tok, "unexpected %s", p.tok)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	// Each top-level condition has one or more alternatives; the queries
+	// are every combination of them.
+	var conds []*condNode
+	if root != nil {
+		conds = flattenNode("and", root)
+	}
+	unions := [][]lowered{nil}
+	for _, c := range conds {
+		alts, err := p.lower(c)
+		if err != nil {
+			return nil, err
+		}
+		if len(unions)*len(alts) > MaxQueryUnion {
+			return nil, &ParseError{Line: c.line, Column: c.col, Msg: fmt.Sprintf(
+				"or splits the query into more than %d queries", MaxQueryUnion)}
+		}
+		var next [][]lowered
+		for _, u := range unions {
+			for _, alt := range alts {
+				next = append(next, append(append([]lowered(nil), u...), alt...))
+			}
+		}
+		unions = next
+	}
+
+	// Conditions are added one at a time, so a condition the Query format
+	// cannot express is reported at its own position.
+	queries := make([]*Query, len(unions))
+	for i, conj := range unions {
+		qb := *b
+		qb.selects = append([]string(nil), b.selects...)
+		qb.orderBy = append([]Order(nil), b.orderBy...)
+		for _, l := range conj {
+			qb.And(l.pred)
+			if _, errs := qb.build(); len(errs) > 0 {
+				return nil, &ParseError{Line: l.node.line, Column: l.node.col, Msg: errs[len(errs)-1].Error()}
+			}
+		}
+		q, err := qb.Build()
+		if err != nil {
+			return nil, err
+		}
+		queries[i] = q
+	}
+	return queries, nil
+}
+
+// lowered is a condition turned into a Predicate, with the node it came from
+// for error positions.
+type lowered struct {
+	pred Predicate
+	node *condNode
+}
+
+// lower returns the alternatives of a top-level condition, each a list of
+// predicates that must all hold. A condition a Query can express has a
+// single alternative. Others are an error unless the parser splits them, in
+// which case they are expanded into an or of ands of comparisons.
+func (p *queryParser) lower(n *condNode) ([][]lowered, error) {
+	pred, err := lowerCondition(n)
+	if err == nil {
+		return [][]lowered{{{pred, n}}}, nil
+	}
+	if !p.split {
+		return nil, err
+	}
+	alts, ok := expandNode(n)
+	if !ok {
+		return nil, &ParseError{Line: n.line, Column: n.col, Msg: fmt.Sprintf(
+			"or splits the query into more than %d queries", MaxQueryUnion)}
+	}
+	return alts, nil
+}
+
+// expandNode rewrites n as an or of ands of comparisons. It reports false if
+// that needs more than MaxQueryUnion alternatives.
+func expandNode(n *condNode) ([][]lowered, bool) {
+	switch n.op {
+	case "cmp":
+		return [][]lowered{{{n.pred, n}}}, true
+	case "or":
+		var alts [][]lowered
+		for _, c := range n.children {
+			sub, ok := expandNode(c)
+			if !ok || len(alts)+len(sub) > MaxQueryUnion {
+				return nil, false
+			}
+			alts = append(alts, sub...)
+		}
+		return alts, true
+	}
+	alts := [][]lowered{nil}
+	for _, c := range n.children {
+		sub, ok := expandNode(c)
+		if !ok || len(alts)*len(sub) > MaxQueryUnion {
+			return nil, false
+		}
+		var next [][]lowered
+		for _, a := range alts {
+			for _, s := range sub {
+				next = append(next, append(append([]lowered(nil), a...), s...))
+			}
+		}
+		alts = next
+	}
+	return alts, true
+}
+
+// atClauseEnd reports whether the conditions are over: at the end of the
+// query or an order by, limit or offset clause.
+func (p *queryParser) atClauseEnd() bool {
+	return p.tok.kind == tokEOF || p.tok.is("order") || p.tok.is("limit") || p.tok.is("offset")
+}
+
+func (p *queryParser) count(clause string) int {
+	t := p.tok
+	if t.kind != tokNumber {
+		p.fail(t, "expected a number after %s, found %s", clause, t)
+		return 0
+	}
+	n, err := strconv.Atoi(t.text)
+	if err != nil || n < 0 {
+		p.fail(t, "%s must be a non-negative whole number, got %s", clause, t.text)
+	}
+	p.next()
+	return n
+}
+
+// name parses a possibly qualified name such as username or attributes.key.
+func (p *queryParser) name() string {
+	parts := []string{p.ident()}
+	for p.err == nil && p.tok.is(".") {
+		p.next()
+		parts = append(parts, p.ident())
+	}
+	return strings.Join(parts, ".")
+}
+
+func (p *queryParser) ident() string {
+	t := p.tok
+	if t.kind != tokIdent && t.kind != tokQuotedIdent {
+		p.fail(t, "expected a name, found %s", t)
+		return ""
+	}
+	p.next()
+	return t.text
+}
+
+func (p *queryParser) orExpr() *condNode {
+	first := p.tok
+	left := p.andExpr()
+	if !p.tok.is("or") {
+		return left
+	}
+	n := &condNode{op: "or", children: []*condNode{left}, line: first.line, col: first.col}
+	for p.err == nil && p.tok.is("or") {
+		p.next()
+		n.children = append(n.children, p.andExpr())
+	}
+	return n
+}
+
+func (p *queryParser) andExpr() *condNode {
+	first := p.tok
+	left := p.factor()
+	if !p.tok.is("and") {
+		return left
+	}
+	n := &condNode{op: "and", children: []*condNode{left}, line: first.line, col: first.col}
+	for p.err == nil && p.tok.is("and") {
+		p.next()
+		n.children = append(n.children, p.factor())
+	}
+	return n
+}
+
+func (p *queryParser) factor() *condNode {
+	if p.tok.is("(") {
+		p.next()
+		n := p.orExpr()
+		p.expect(")")
+		return n
+	}
+	return p.comparison()
+}
+
+// fieldTables maps the table prefixes of field names to their tables.
+var fieldTables = map[string]string{
+	usersTable:      usersTable,
+	attributesTable: attributesTable,
+	traitsTable:     traitsTable,
+	eventsTable:     eventsTable,
+}
+
+// textOperators maps the comparison operators of the text language to the
+// query language.
+var textOperators = map[string]Operator{
+	"=": OpEq, "!=": OpNe, "<>": OpNe, ">": OpGt, ">=": OpGte, "<": OpLt, "<=": OpLte,
+	"~": OpRegex, "regex": OpRegex, "like": OpLike, "ilike": OpILike, "in": OpIn,
+}
+
+func (p *queryParser) comparison() *condNode {
+	start := p.tok
+	n := &condNode{op: "cmp", line: start.line, col: start.col}
+
+	first := p.ident()
+	field := Column(first)
+	if p.err == nil && p.tok.is(".") {
+		p.next()
+		key := p.ident()
+		table, ok := fieldTables[first]
+		if start.kind == tokQuotedIdent || !ok {
+			p.fail(start, "unknown table %q, expected users, attributes, traits or events", first)
+		}
+		field = Field{table: table, name: key}
+	}
+	if p.err != nil {
+		return n
+	}
+
+	opTok := p.tok
+	var op Operator
+	if opTok.is("not") {
+		p.next()
+		if !p.tok.is("in") {
+			p.fail(p.tok, "expected in after not, found %s", p.tok)
+			return n
+		}
+		op = OpNin
+	} else if o, ok := textOperators[strings.ToLower(opTok.text)]; ok && (opTok.kind == tokIdent || opTok.kind == tokPunct) {
+		op = o
+	} else {
+		p.fail(opTok, "expected an operator, found %s", opTok)
+		return n
+	}
+	p.next()
+
+	var value interface{}
+	if op == OpIn || op == OpNin {
+		p.expect("(")
+		values := []interface{}{}
+		for p.err == nil && !p.tok.is(")") {
+			values = append(values, p.value())
+			if !p.tok.is(",") {
+				break
+			}
+			p.next()
+		}
+		p.expect(")")
+		value = values
+	} else {
+		valTok := p.tok
+		value = p.value()
+		if _, isString := value.(string); p.err == nil && !isString && (op == OpLike || op == OpILike || op == OpRegex) {
+			p.fail(valTok, "%s needs a string, found %s", opTok.text, valTok)
+		}
+	}
+	n.pred = field.is(op, value)
+	return n
+}
+
+func (p *queryParser) value() interface{} {
+	t := p.tok
+	var v interface{}
+	switch {
+	case t.kind == tokString:
+		v = t.text
+	case t.kind == tokNumber && !strings.ContainsAny(t.text, ".eE"):
+		// Integers are kept exact rather than rounded to a float.
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			v = i
+		} else if u, err := strconv.ParseUint(t.text, 10, 64); err == nil {
+			v = u
+		} else {
+			p.fail(t, "integer %s is out of range", t.text)
+		}
+	case t.kind == tokNumber:
+		if f, err := strconv.ParseFloat(t.text, 64); err == nil {
+			v = f
+		} else {
+			p.fail(t, "invalid number %s", t.text)
+		}
+	case t.is("true"):
+		v = true
+	case t.is("false"):
+		v = false
+	case t.is("null"):
+		v = nil
+	default:
+		p.fail(t, "expected a value, found %s", t)
+	}
+	p.next()
+	return v
+}
+
+// flattenNode returns the operands of nested op nodes starting at n.
+func flattenNode(op string, n *condNode) []*condNode {
+	if n.op != op {
+		return []*condNode{n}
+	}
+	var flat []*condNode
+	for _, c := range n.children {
+		flat = append(flat, flattenNode(op, c)...)
+	}
+	return flat
+}
+
+// lowerCondition turns a top-level condition into a Predicate. Or may only
+// hold comparisons or ands of comparisons, all on the same one of
+// attributes, traits or events: the Query format has no way to express
+// anything else, such as username = 'x' or traits.plan = 'pro'.
+func lowerCondition(n *condNode) (Predicate, error) {
+	if n.op == "cmp" {
+		return n.pred, nil
+	}
+	var table string
+	var branches []Predicate
+	for _, branch := range flattenNode("or", n) {
+		var preds []Predicate
+		for _, c := range flattenNode("and", branch) {
+			if c.op != "cmp" {
+				return Predicate{}, &ParseError{Line: c.line, Column: c.col, Msg: "or nested inside and inside or cannot be expressed in a query"}
+			}
+			switch t := c.pred.field.table; {
+			case t == usersTable:
+				return Predicate{}, &ParseError{Line: c.line, Column: c.col, Msg: fmt.Sprintf(
+					"or cannot include %s, a column of the users table; or only combines conditions on the same one of attributes, traits or events",
+					c.pred.field.name)}
+			case table == "":
+				table = t
+			case t != table:
+				return Predicate{}, &ParseError{Line: c.line, Column: c.col, Msg: fmt.Sprintf(
+					"or cannot combine conditions on %s and %s; or only combines conditions on the same one of attributes, traits or events",
+					table, t)}
+			}
+			preds = append(preds, c.pred)
+		}
+		branches = append(branches, And(preds...))
+	}
+	return Or(branches...), nil
+}
+
+type queryFormatter struct {
+	conds []string
+}
+
+func (f *queryFormatter) format(q *Query) (string, error) {
+	if q == nil {
+		return "", nil
+	}
+
+	for _, col := range sortedKeys(q.Filter) {
+		for _, op := range sortedKeys(q.Filter[col]) {
+			s, err := formatComparison(formatIdent(col), Operator(op), q.Filter[col][op])
+			if err != nil {
+				return "", err
+			}
+			f.conds = append(f.conds, s)
+		}
+	}
+	for i, j := range q.Joins {
+		if j.On != joinOn(j.Table) {
+			return "", fmt.Errorf("userup: join %d on %q cannot be written as text", i, j.On)
+		}
+		if len(j.Filter) == 0 {
+			return "", fmt.Errorf("userup: join %d of %s has no filter and cannot be written as text", i, j.Table)
+		}
+		for _, table := range sortedKeys(j.Filter) {
+			if table != j.Table {
+				return "", fmt.Errorf("userup: join %d of %s filters on %s and cannot be written as text", i, j.Table, table)
+			}
+			conds, err := formatJoinCondition(table, j.Filter[table], true)
+			if err != nil {
+				return "", err
+			}
+			f.conds = append(f.conds, conds...)
+		}
+	}
+
+	var parts []string
+	if len(q.Select) > 0 {
+		names := make([]string, len(q.Select))
+		for i, s := range q.Select {
+			names[i] = formatName(s)
+		}
+		parts = append(parts, "select "+strings.Join(names, ", "))
+		if len(f.conds) > 0 {
+			parts = append(parts, "where")
+		}
+	}
+	if len(f.conds) > 0 {
+		parts = append(parts, strings.Join(f.conds, " and "))
+	}
+	if len(q.OrderBy) > 0 {
+		orders := make([]string, len(q.OrderBy))
+		for i, o := range q.OrderBy {
+			dir := strings.ToLower(o.Direction)
+			if dir != "asc" && dir != "desc" {
+				return "", fmt.Errorf("userup: order by %s: direction must be %s or %s, got %q", o.Field, Asc, Desc, o.Direction)
+			}
+			orders[i] = formatName(o.Field) + " " + dir
+		}
+		parts = append(parts, "order by "+strings.Join(orders, ", "))
+	}
+	if q.Limit != 0 {
+		parts = append(parts, fmt.Sprintf("limit %d", q.Limit))
+	}
+	if q.Offset != 0 {
+		parts = append(parts, fmt.Sprintf("offset %d", q.Offset))
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// formatJoinCondition renders the filter of a join of table.
+func formatJoinCondition(table string, cond map[string]interface{}, allowOr bool) ([]string, error) {
+	var conds []string
+	for _, key := range sortedKeys(cond) {
+		value := cond[key]
+		if key == "$or" && allowOr {
+			branches, ok := asList(value)
+			if !ok {
+				return nil, fmt.Errorf("userup: $or of %s must be a list, got %T", table, value)
+			}
+			var alts []string
+			for _, branch := range branches {
+				m, ok := asMap(branch)
+				if !ok {
+					return nil, fmt.Errorf("userup: $or of %s holds %T, not a condition", table, branch)
+				}
+				parts, err := formatJoinCondition(table, m, false)
+				if err != nil {
+					return nil, err
+				}
+				alt := strings.Join(parts, " and ")
+				if len(parts) > 1 {
+					alt = "(" + alt + ")"
+				}
+				alts = append(alts, alt)
+			}
+			conds = append(conds, "("+strings.Join(alts, " or ")+")")
+			continue
+		}
+		if strings.HasPrefix(key, "$") {
+			return nil, fmt.Errorf("userup: unexpected operator %s in filter of %s", key, table)
+		}
+
+		name := table + "." + formatIdent(key)
+		ops, isOps := asMap(value)
+		if !isOps {
+			s, err := formatComparison(name, OpEq, value)
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, s)
+			continue
+		}
+		for _, op := range sortedKeys(ops) {
+			s, err := formatComparison(name, Operator(op), ops[op])
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, s)
+		}
+	}
+	return conds, nil
+}
+
+// operatorText maps query operators to the text language.
+var operatorText = map[Operator]string{
+	OpEq: "=", OpNe: "!=", OpGt: ">", OpGte: ">=", OpLt: "<", OpLte: "<=",
+	OpRegex: "regex", OpLike: "like", OpILike: "ilike", OpIn: "in", OpNin: "not in",
+}
+
+func formatComparison(name string, op Operator, value interface{}) (string, error) {
+	text, ok := operatorText[op]
+	if !ok {
+		return "", fmt.Errorf("userup: %s: unknown operator %q", name, op)
+	}
+	if op == OpIn || op == OpNin {
+		list, ok := asList(value)
+		if !ok {
+			return "", fmt.Errorf("userup: %s: %s needs a list, got %T", name, op, value)
+		}
+		items := make([]string, len(list))
+		for i, item := range list {
+			s, err := formatValue(item)
+			if err != nil {
+				return "", fmt.Errorf("userup: %s: %w", name, err)
+			}
+			items[i] = s
+		}
+		return fmt.Sprintf("%s %s (%s)", name, text, strings.Join(items, ", ")), nil
+	}
+	s, err := formatValue(value)
+	if err != nil {
+		return "", fmt.Errorf("userup: %s: %w", name, err)
+	}
+	return fmt.Sprintf("%s %s %s", name, text, s), nil
+}
+
+func formatValue(v interface{}) (string, error) {
+	if v == nil {
+		return "null", nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return quoteString(rv.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("value of type %T cannot be written as text", v)
+}
+
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// keywords lists the words of the text language that must be quoted when
+// used as names.
+var keywords = map[string]bool{
+	"and": true, "or": true, "not": true, "in": true, "like": true, "ilike": true,
+	"regex": true, "order": true, "by": true, "asc": true, "desc": true,
+	"limit": true, "offset": true, "select": true, "where": true,
+	"true": true, "false": true, "null": true,
+}
+
+// formatName writes each part of a dotted name as a plain identifier where
+// possible and quoted otherwise.
+func formatName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = formatIdent(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+func formatIdent(s string) string {
+	plain := s != "" && !keywords[strings.ToLower(s)]
+	for i, r := range s {
+		if (i == 0 && !isIdentStart(r)) || !isIdentPart(r) {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
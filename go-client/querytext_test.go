@@ -0,0 +1,329 @@
+package userup
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want *Query
+	}{
+		{
+			"",
+			&Query{},
+		},
+		{
+			"username = 'jane'",
+			&Query{Filter: map[string]Condition{"username": {"$eq": "jane"}}},
+		},
+		{
+			"attributes.vip_level >= 2 and username ilike 'j%' and traits.plan in ('pro','team') order by id desc limit 20",
+			&Query{
+				Filter:  map[string]Condition{"username": {"$ilike": "j%"}},
+				OrderBy: []Order{{Field: "id", Direction: Desc}},
+				Limit:   20,
+				Joins: []Join{
+					JoinAttributes(Condition{"vip_level": Condition{"$gte": int64(2)}}),
+					JoinTraits(Condition{"plan": Condition{"$in": []interface{}{"pro", "team"}}}),
+				},
+			},
+		},
+		{
+			"traits.plan = 'pro' or (traits.plan = 'team' and traits.seats > 10)",
+			&Query{Joins: []Join{JoinTraits(Condition{"$or": []Condition{
+				{"plan": "pro"},
+				{"plan": "team", "seats": Condition{"$gt": int64(10)}},
+			}})}},
+		},
+		{
+			"SELECT id, username WHERE id NOT IN (1, 2) ORDER BY username ASC, id DESC LIMIT 5 OFFSET 10",
+			&Query{
+				Filter:  map[string]Condition{"id": {"$nin": []interface{}{int64(1), int64(2)}}},
+				Select:  []string{"id", "username"},
+				OrderBy: []Order{{Field: "username", Direction: Asc}, {Field: "id", Direction: Desc}},
+				Limit:   5,
+				Offset:  10,
+			},
+		},
+		{
+			"select id",
+			&Query{Select: []string{"id"}},
+		},
+		{
+			"select id, attributes.email order by id limit 3",
+			&Query{Select: []string{"id", "attributes.email"}, OrderBy: []Order{{Field: "id", Direction: Asc}}, Limit: 3},
+		},
+		{
+			"where events.type = 'login'",
+			&Query{Joins: []Join{JoinEvents(Condition{"type": "login"})}},
+		},
+		{
+			`attributes."2fa" = true and attributes."order" != null and attributes.score < -1.5e3`,
+			&Query{Joins: []Join{JoinAttributes(Condition{
+				"2fa":   true,
+				"order": Condition{"$ne": nil},
+				"score": Condition{"$lt": -1500.0},
+			})}},
+		},
+		{
+			"attributes.big = 18446744073709551615 and attributes.small = -9223372036854775808",
+			&Query{Joins: []Join{JoinAttributes(Condition{
+				"big":   uint64(18446744073709551615),
+				"small": int64(-9223372036854775808),
+			})}},
+		},
+		{
+			"username regex '^j' and email like '%@example.com' and name ~ 'it''s'",
+			&Query{Filter: map[string]Condition{
+				"username": {"$regex": "^j"},
+				"email":    {"$like": "%@example.com"},
+				"name":     {"$regex": "it's"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := ParseQuery(tt.text)
+			if err != nil {
+				t.Fatalf("ParseQuery: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseQuery =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		text      string
+		line, col int
+		msg       string
+	}{
+		{"attributes.vip_level >= 2 and (username ilike 'j%' or traits.plan in ('pro','team'))", 1, 32, "or cannot include username"},
+		{"traits.plan = 'pro' or attributes.plan = 'pro'", 1, 24, "or cannot combine conditions on traits and attributes"},
+		{"traits.a = 1 or (traits.b = 2 and (traits.c = 3 or traits.d = 4))", 1, 36, "or nested inside and inside or"},
+		{"attributes.n = 99999999999999999999", 1, 16, "integer 99999999999999999999 is out of range"},
+		{"attributes.n = -9223372036854775809", 1, 16, "out of range"},
+		{"select id username = 'x'", 1, 11, `expected where, found "username"`},
+		{"select id where", 1, 16, "expected a name, found end of query"},
+		{"username = 'jane'\n  and foo.bar = 1", 2, 7, `unknown table "foo"`},
+		{"username == 'x'", 1, 11, "expected a value"},
+		{"username = 'unterminated", 1, 12, "unterminated '"},
+		{"username like 5", 1, 15, "like needs a string"},
+		{"username = 1 limit -1", 1, 20, "limit must be a non-negative whole number"},
+		{"id not 5", 1, 8, "expected in after not"},
+		{"username = 'x' extra", 1, 16, `unexpected "extra"`},
+		{"username = 1\n\tand 2fa = 1", 2, 6, "quote names that start with a digit"},
+		{"username = 1 and username = 2", 1, 18, "more than one $eq"},
+		{"username ? 1", 1, 10, "unexpected character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			_, err := ParseQuery(tt.text)
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("ParseQuery error = %v, want a *ParseError", err)
+			}
+			if pe.Line != tt.line || pe.Column != tt.col {
+				t.Errorf("error at %d:%d, want %d:%d (%v)", pe.Line, pe.Column, tt.line, tt.col, err)
+			}
+			if !strings.Contains(pe.Msg, tt.msg) {
+				t.Errorf("error %q does not mention %q", pe.Msg, tt.msg)
+			}
+		})
+	}
+}
+
+func TestParseQueries(t *testing.T) {
+	users := func(filter map[string]Condition, joins ...Join) *Query {
+		return &Query{Filter: filter, Joins: joins, OrderBy: []Order{{Field: "id", Direction: Desc}}, Limit: 20}
+	}
+	vip := JoinAttributes(Condition{"vip_level": Condition{"$gte": int64(2)}})
+	tests := []struct {
+		text string
+		want []*Query
+	}{
+		{
+			"attributes.vip_level >= 2 and (username ilike 'j%' or traits.plan in ('pro','team')) order by id desc limit 20",
+			[]*Query{
+				users(map[string]Condition{"username": {"$ilike": "j%"}}, vip),
+				users(nil, vip, JoinTraits(Condition{"plan": Condition{"$in": []interface{}{"pro", "team"}}})),
+			},
+		},
+		{
+			// A condition a Query can express is not split.
+			"attributes.vip_level >= 2 and (traits.plan = 'pro' or traits.seats > 5) order by id desc limit 20",
+			[]*Query{users(nil, vip, JoinTraits(Condition{"$or": []Condition{{"plan": "pro"}, {"seats": Condition{"$gt": int64(5)}}}}))},
+		},
+		{
+			"(username = 'a' or attributes.x = 1) and (traits.y = 2 or (events.type = 'login' and id > 3)) order by id desc limit 20",
+			[]*Query{
+				users(map[string]Condition{"username": {"$eq": "a"}}, JoinTraits(Condition{"y": int64(2)})),
+				users(map[string]Condition{"username": {"$eq": "a"}, "id": {"$gt": int64(3)}}, JoinEvents(Condition{"type": "login"})),
+				users(nil, JoinAttributes(Condition{"x": int64(1)}), JoinTraits(Condition{"y": int64(2)})),
+				users(map[string]Condition{"id": {"$gt": int64(3)}}, JoinAttributes(Condition{"x": int64(1)}), JoinEvents(Condition{"type": "login"})),
+			},
+		},
+		{
+			"traits.a = 1 or (traits.b = 2 and (traits.c = 3 or traits.d = 4)) order by id desc limit 20",
+			[]*Query{
+				users(nil, JoinTraits(Condition{"a": int64(1)})),
+				users(nil, JoinTraits(Condition{"b": int64(2), "c": int64(3)})),
+				users(nil, JoinTraits(Condition{"b": int64(2), "d": int64(4)})),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := ParseQueries(tt.text)
+			if err != nil {
+				t.Fatalf("ParseQueries: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseQueries =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueriesErrors(t *testing.T) {
+	tests := []struct {
+		text      string
+		line, col int
+		msg       string
+	}{
+		{"username = 'a' and (username = 'b' or traits.x = 1)", 1, 21, "more than one $eq"},
+		{"(id = 1 or traits.a = 1) and (id = 2 or traits.b = 1) and (id = 3 or traits.c = 1) and (id = 4 or traits.d = 1) and (id = 5 or traits.e = 1)",
+			1, 118, "more than 16 queries"},
+		{"(id = 1 or traits.a = 1 or events.b = 1) and (id = 2 or traits.b = 1 or events.c = 1) and (id = 3 or traits.c = 1 or events.d = 1)",
+			1, 92, "more than 16 queries"},
+		{"id = 1 or username = 'x' and", 1, 29, "expected a name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			_, err := ParseQueries(tt.text)
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("ParseQueries error = %v, want a *ParseError", err)
+			}
+			if pe.Line != tt.line || pe.Column != tt.col {
+				t.Errorf("error at %d:%d, want %d:%d (%v)", pe.Line, pe.Column, tt.line, tt.col, err)
+			}
+			if !strings.Contains(pe.Msg, tt.msg) {
+				t.Errorf("error %q does not mention %q", pe.Msg, tt.msg)
+			}
+		})
+	}
+}
+
+func TestFormatQuery(t *testing.T) {
+	tests := []struct {
+		query *Query
+		want  string
+	}{
+		{nil, ""},
+		{&Query{}, ""},
+		{&Query{Select: []string{"id"}}, "select id"},
+		{
+			&Query{Select: []string{"id", "order"}, Filter: map[string]Condition{"username": {"$eq": "o'neil"}}},
+			`select id, "order" where username = 'o''neil'`,
+		},
+		{
+			&Query{
+				Filter:  map[string]Condition{"id": {"$gt": 10, "$lte": uint64(20)}},
+				OrderBy: []Order{{Field: "id", Direction: "desc"}},
+				Limit:   20,
+				Offset:  40,
+			},
+			"id > 10 and id <= 20 order by id desc limit 20 offset 40",
+		},
+		{
+			&Query{Joins: []Join{
+				JoinAttributes(Condition{"vip_level": Condition{"$gte": 2}, "2fa": true}),
+				JoinTraits(Condition{"$or": []Condition{{"plan": "pro"}, {"plan": "team", "seats": Condition{"$in": []int{5, 10}}}}}),
+			}},
+			`attributes."2fa" = true and attributes.vip_level >= 2 and (traits.plan = 'pro' or (traits.plan = 'team' and traits.seats in (5, 10)))`,
+		},
+		{
+			&Query{Joins: []Join{JoinAttributes(Condition{"utm.source": "ads"})}},
+			`attributes."utm.source" = 'ads'`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := FormatQuery(tt.query)
+		if err != nil {
+			t.Errorf("FormatQuery(%+v): %v", tt.query, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("FormatQuery(%+v) =\n%s\nwant\n%s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestFormatQueryErrors(t *testing.T) {
+	for _, q := range []*Query{
+		{Joins: []Join{{Table: attributesTable, On: "users.id = attributes.owner_id", Filter: map[string]Condition{attributesTable: {"a": 1}}}}},
+		{Joins: []Join{{Table: attributesTable, On: joinOn(attributesTable)}}},
+		{Filter: map[string]Condition{"id": {"$between": []int{1, 2}}}},
+		{Filter: map[string]Condition{"id": {"$eq": struct{}{}}}},
+		{OrderBy: []Order{{Field: "id", Direction: "sideways"}}},
+	} {
+		if text, err := FormatQuery(q); err == nil {
+			t.Errorf("FormatQuery(%+v) = %q, want an error", q, text)
+		}
+	}
+}
+
+// TestFormatParseRoundTrip checks that ParseQuery reads back what
+// FormatQuery writes.
+func TestFormatParseRoundTrip(t *testing.T) {
+	queries := []*Query{
+		{},
+		{Select: []string{"id"}},
+		{Select: []string{"id", "username"}, Limit: 10},
+		{Select: []string{"id"}, Filter: map[string]Condition{"id": {"$in": []interface{}{int64(1), int64(2)}}}},
+		{
+			Filter:  map[string]Condition{"username": {"$ilike": "j%"}, "id": {"$ne": int64(3)}},
+			OrderBy: []Order{{Field: "id", Direction: Desc}, {Field: "username", Direction: Asc}},
+			Limit:   20,
+			Offset:  5,
+			Joins: []Join{
+				JoinAttributes(Condition{"vip_level": Condition{"$gte": int64(2)}, "my key": "x"}),
+				JoinTraits(Condition{"$or": []Condition{
+					{"plan": "pro"},
+					{"plan": "team", "seats": Condition{"$nin": []interface{}{int64(1), 2.5, nil, false}}},
+				}}),
+				JoinEvents(Condition{"type": Condition{"$regex": `^log(in|out)$`}}),
+			},
+		},
+		{Joins: []Join{JoinAttributes(Condition{"big": uint64(1<<63 + 1), "neg": int64(-1 << 62)})}},
+		{
+			Filter: map[string]Condition{"a.b": {"$eq": "x"}},
+			Joins: []Join{
+				JoinAttributes(Condition{"utm.source": "ads", "utm.medium": Condition{"$in": []interface{}{"cpc"}}}),
+				JoinTraits(Condition{"$or": []Condition{{"plan.tier": "pro"}, {"seats": int64(2)}}}),
+			},
+		},
+	}
+	for _, q := range queries {
+		text, err := FormatQuery(q)
+		if err != nil {
+			t.Errorf("FormatQuery(%+v): %v", q, err)
+			continue
+		}
+		got, err := ParseQuery(text)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, q) {
+			t.Errorf("round trip through %q gave\n%#v\nwant\n%#v", text, got, q)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package userup
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// RetryPolicy controls how failed calls are retried. Only calls that are
+// safe to repeat are retried: reads, LogEvent (which carries a stable event
+// ID) and, when RetryAddUser is set, AddUser.
+type RetryPolicy struct {
+	MaxAttempts    int           // Total attempts including the first. 1 or less disables retries.
+	InitialBackoff time.Duration // Wait before the first retry.
+	MaxBackoff     time.Duration // Upper bound on the wait between attempts.
+	Multiplier     float64       // Growth factor of the wait after each attempt.
+	Jitter         float64       // Fraction, between 0 and 1, the wait is randomized by.
+	RetryableCodes []codes.Code  // Status codes that trigger a retry.
+	RetryAddUser   bool          // Also retry AddUser. Only safe when users carry a unique ExternalID or UUID.
+
+	// OnRetry, if set, is called before each retry with the full gRPC method
+	// name, the number of the attempt about to be made and the error of the
+	// previous one.
+	OnRetry func(method string, attempt int, err error)
+}
+
+// DefaultRetryPolicy is the policy used when WithRetry is not given.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	RetryableCodes: []codes.Code{codes.Unavailable, codes.DeadlineExceeded},
+}
+
+// WithRetry replaces DefaultRetryPolicy. Pass a policy with MaxAttempts of 1
+// to disable retries.
+func WithRetry(policy RetryPolicy) ClientOption {
+	return func(o *clientOptions) {
+		o.retry = &policy
+	}
+}
+
+// idempotentMethods lists the RPCs that can be retried without risk of
+// applying a change twice.
+var idempotentMethods = map[string]bool{
+	userapi.Users_Get_FullMethodName:                        true,
+	userapi.Users_Find_FullMethodName:                       true,
+	userapi.Users_SearchUserTraits_FullMethodName:           true,
+	userapi.Users_GetUsersByTraits_FullMethodName:           true,
+	userapi.Users_GetUsersByAggregatedTraits_FullMethodName: true,
+	userapi.Users_GetAggregateForUsers_FullMethodName:       true,
+	userapi.Users_GetUsersByEvents_FullMethodName:           true,
+	userapi.Users_SearchEvents_FullMethodName:               true,
+	userapi.Users_NaturalBreaks_FullMethodName:              true,
+	userapi.Users_NaturalBreaksQueried_FullMethodName:       true,
+	userapi.Users_QueryUsers_FullMethodName:                 true,
+	userapi.Users_QueryAttributes_FullMethodName:            true,
+	userapi.Users_QueryTraits_FullMethodName:                true,
+	userapi.Users_QueryEvents_FullMethodName:                true,
+	userapi.Users_GetSessions_FullMethodName:                true,
+	userapi.Users_GetSessionEvents_FullMethodName:           true,
+	userapi.Integrations_ListIntegrations_FullMethodName:    true,
+	userapi.Integrations_GetIntegration_FullMethodName:      true,
+	userapi.Integrations_GetJobHistory_FullMethodName:       true,
+
+	// Events carry a client generated ID, so the server can discard
+	// duplicates of a retried LogEvent.
+	userapi.Users_LogEvent_FullMethodName: true,
+}
+
+// CallStats records how a call went. Attach one to a context with
+// WithCallStats to find out how many attempts a call took.
+type CallStats struct {
+	Attempts int // Number of attempts made, including the first.
+}
+
+type callStatsKey struct{}
+
+// WithCallStats returns a context that records the outcome of the call it is
+// used for into stats.
+func WithCallStats(ctx context.Context, stats *CallStats) context.Context {
+	return context.WithValue(ctx, callStatsKey{}, stats)
+}
+
+// retryInterceptor retries idempotent calls according to policy.
+func retryInterceptor(policy RetryPolicy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		stats, _ := ctx.Value(callStatsKey{}).(*CallStats)
+
+		maxAttempts := 1
+		if idempotentMethods[method] || (policy.RetryAddUser && method == userapi.Users_Create_FullMethodName) {
+			maxAttempts = max(policy.MaxAttempts, 1)
+		}
+
+		var err error
+		for attempt := 1; ; attempt++ {
+			if stats != nil {
+				stats.Attempts = attempt
+			}
+
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || attempt >= maxAttempts || !policy.retryable(err) || ctx.Err() != nil {
+				return err
+			}
+
+			if policy.OnRetry != nil {
+				policy.OnRetry(method, attempt+1, err)
+			}
+
+			timer := time.NewTimer(policy.backoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	code := status.Code(err)
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns how long to wait after the given attempt failed.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(math.Max(p.Multiplier, 1), float64(attempt-1))
+	if p.MaxBackoff > 0 {
+		d = math.Min(d, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
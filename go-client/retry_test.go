@@ -0,0 +1,176 @@
+package userup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// failingServer fails the first failures calls of Get, Create and Update
+// with code, and counts every call.
+type failingServer struct {
+	userapi.UnimplementedUsersServer
+	code     codes.Code
+	failures int32
+	calls    atomic.Int32
+}
+
+func (s *failingServer) serve(id *userapi.UserID) (*userapi.UserResponse, error) {
+	if s.calls.Add(1) <= s.failures {
+		return nil, status.Error(s.code, "try again")
+	}
+	return &userapi.UserResponse{Id: id, Username: "u"}, nil
+}
+
+func (s *failingServer) Get(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	return s.serve(req.Id)
+}
+
+func (s *failingServer) Update(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	return s.serve(req.Id)
+}
+
+func (s *failingServer) Create(ctx context.Context, req *userapi.NewUser) (*userapi.UserResponse, error) {
+	return s.serve(&userapi.UserID{Id: 1})
+}
+
+// fastRetry retries quickly so tests do not wait on backoff.
+var fastRetry = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     time.Millisecond,
+	Multiplier:     2,
+	RetryableCodes: []codes.Code{codes.Unavailable},
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      codes.Code
+		failures  int32
+		addUser   bool
+		call      func(ctx context.Context, us *UserService) error
+		wantCalls int32
+		wantErr   bool
+	}{
+		{"read succeeds after retries", codes.Unavailable, 2, false, getUser, 3, false},
+		{"read gives up after MaxAttempts", codes.Unavailable, 10, false, getUser, 3, true},
+		{"non-retryable code", codes.NotFound, 10, false, getUser, 1, true},
+		{"update is not retried", codes.Unavailable, 10, false, updateUser, 1, true},
+		{"AddUser is not retried by default", codes.Unavailable, 10, false, addUser, 1, true},
+		{"RetryAddUser retries AddUser", codes.Unavailable, 1, true, addUser, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &failingServer{code: tt.code, failures: tt.failures}
+			policy := fastRetry
+			policy.RetryAddUser = tt.addUser
+			client := startTestServer(t, srv, WithRetry(policy))
+
+			var stats CallStats
+			ctx := WithCallStats(context.Background(), &stats)
+			err := tt.call(ctx, client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("call error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := srv.calls.Load(); got != tt.wantCalls {
+				t.Errorf("server got %d calls, want %d", got, tt.wantCalls)
+			}
+			if stats.Attempts != int(tt.wantCalls) {
+				t.Errorf("CallStats.Attempts = %d, want %d", stats.Attempts, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func getUser(ctx context.Context, us *UserService) error {
+	_, err := us.GetUser(ctx, UID(1))
+	return err
+}
+
+func updateUser(ctx context.Context, us *UserService) error {
+	_, err := us.UpdateUser(ctx, &User{ID: UID(1), Username: "u"})
+	return err
+}
+
+func addUser(ctx context.Context, us *UserService) error {
+	_, err := us.AddUser(ctx, &User{Username: "u", ID: ExtID("e")})
+	return err
+}
+
+func TestRetryOnRetry(t *testing.T) {
+	srv := &failingServer{code: codes.Unavailable, failures: 10}
+	type retry struct {
+		method  string
+		attempt int
+	}
+	var mu sync.Mutex
+	var retries []retry
+	policy := fastRetry
+	policy.OnRetry = func(method string, attempt int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		retries = append(retries, retry{method, attempt})
+		if status.Code(err) != codes.Unavailable {
+			t.Errorf("OnRetry got error %v, want Unavailable", err)
+		}
+	}
+	client := startTestServer(t, srv, WithRetry(policy))
+
+	if err := getUser(context.Background(), client); err == nil {
+		t.Fatal("GetUser succeeded")
+	}
+	want := []retry{{userapi.Users_Get_FullMethodName, 2}, {userapi.Users_Get_FullMethodName, 3}}
+	if len(retries) != len(want) || retries[0] != want[0] || retries[1] != want[1] {
+		t.Errorf("OnRetry calls = %v, want %v", retries, want)
+	}
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+	srv := &failingServer{code: codes.Unavailable, failures: 10}
+	policy := fastRetry
+	policy.InitialBackoff, policy.MaxBackoff = time.Minute, time.Minute
+	client := startTestServer(t, srv, WithRetry(policy))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err := getUser(ctx, client)
+	if err == nil {
+		t.Fatal("GetUser succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GetUser returned after %v; the backoff was not cut short", elapsed)
+	}
+	if got := srv.calls.Load(); got != 1 {
+		t.Errorf("server got %d calls, want 1", got)
+	}
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("GetUser error = %v, want the last attempt's ErrUnavailable", err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	p.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1); got < 80*time.Millisecond || got > 120*time.Millisecond {
+			t.Fatalf("backoff(1) with 20%% jitter = %v, want within 80ms to 120ms", got)
+		}
+	}
+}
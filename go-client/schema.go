@@ -0,0 +1,385 @@
+package userup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FieldType is the type of value a schema allows for an attribute or trait.
+type FieldType string
+
+const (
+	TypeAny     FieldType = ""        // Any value.
+	TypeString  FieldType = "string"  // A string.
+	TypeNumber  FieldType = "number"  // Any number.
+	TypeInteger FieldType = "integer" // A whole number.
+	TypeBool    FieldType = "bool"    // true or false.
+	TypeTime    FieldType = "time"    // A time.Time or RFC 3339 string.
+	TypeList    FieldType = "list"    // A slice or array.
+	TypeObject  FieldType = "object"  // A map or struct.
+)
+
+// FieldSchema describes the values allowed for one attribute or trait key.
+type FieldSchema struct {
+	Type     FieldType     `json:"type,omitempty" yaml:"type,omitempty"`
+	Required bool          `json:"required,omitempty" yaml:"required,omitempty"` // The key must be set when a user is added.
+	Enum     []interface{} `json:"enum,omitempty" yaml:"enum,omitempty"`         // If not empty, the only values allowed.
+	Pattern  string        `json:"pattern,omitempty" yaml:"pattern,omitempty"`   // Regular expression string values must match.
+}
+
+// Schema declares the attribute and trait keys users may carry. Keys that
+// are not declared are rejected, which catches typos such as "userType" for
+// "user_type". Enforce a Schema with WithSchema, or check values with
+// ValidateUser.
+type Schema struct {
+	Attributes map[string]FieldSchema `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+	Traits     map[string]FieldSchema `json:"traits,omitempty" yaml:"traits,omitempty"`
+
+	mu       sync.Mutex
+	patterns map[string]*regexp.Regexp
+}
+
+// LoadSchema reads a Schema from a YAML or JSON file. Files ending in .json
+// are read as JSON, everything else as YAML. The schema is checked with
+// Check before it is returned.
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Schema{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(s)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("userup: schema %s: %w", path, err)
+	}
+	if err := s.Check(); err != nil {
+		return nil, fmt.Errorf("userup: schema %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Check reports problems with the schema itself, such as an unknown type or
+// an invalid pattern.
+func (s *Schema) Check() error {
+	var errs []error
+	check := func(field PatchField, fields map[string]FieldSchema) {
+		for _, key := range sortedKeys(fields) {
+			fs := fields[key]
+			switch fs.Type {
+			case TypeAny, TypeString, TypeNumber, TypeInteger, TypeBool, TypeTime, TypeList, TypeObject:
+			default:
+				errs = append(errs, fmt.Errorf("%s %q: unknown type %q", field, key, fs.Type))
+			}
+			if fs.Pattern != "" {
+				if _, err := s.pattern(fs.Pattern); err != nil {
+					errs = append(errs, fmt.Errorf("%s %q: %w", field, key, err))
+				}
+			}
+		}
+	}
+	check(PatchAttribute, s.Attributes)
+	check(PatchTrait, s.Traits)
+	return errors.Join(errs...)
+}
+
+func (s *Schema) pattern(expr string) (*regexp.Regexp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if re, ok := s.patterns[expr]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if s.patterns == nil {
+		s.patterns = make(map[string]*regexp.Regexp)
+	}
+	s.patterns[expr] = re
+	return re, nil
+}
+
+// SchemaViolation is a single way in which a value breaks a Schema.
+type SchemaViolation struct {
+	Field   PatchField // PatchAttribute or PatchTrait.
+	Key     string
+	Problem string
+}
+
+func (v SchemaViolation) String() string {
+	return fmt.Sprintf("%s %q: %s", v.Field, v.Key, v.Problem)
+}
+
+// SchemaError lists the violations found when checking values against a
+// Schema. Calls rejected by a schema return an *Error matching
+// ErrInvalidArgument that wraps a *SchemaError.
+type SchemaError struct {
+	Violations []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	problems := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		problems[i] = v.String()
+	}
+	return "schema violation: " + strings.Join(problems, "; ")
+}
+
+// ValidateUser checks the attributes and traits of u against the schema,
+// including that every required key is set. It returns a *SchemaError, or nil
+// if u conforms.
+func (s *Schema) ValidateUser(u *User) error {
+	return schemaError(s.checkUser(u, true))
+}
+
+// ValidateAttribute checks a single attribute value against the schema.
+func (s *Schema) ValidateAttribute(key string, value interface{}) error {
+	return schemaError(s.checkValue(PatchAttribute, s.Attributes, key, value))
+}
+
+// ValidateTrait checks a single trait value against the schema.
+func (s *Schema) ValidateTrait(key string, value interface{}) error {
+	return schemaError(s.checkValue(PatchTrait, s.Traits, key, value))
+}
+
+func schemaError(violations []SchemaViolation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	return &SchemaError{Violations: violations}
+}
+
+func (s *Schema) checkUser(u *User, requireAll bool) []SchemaViolation {
+	violations := s.checkValues(PatchAttribute, s.Attributes, u.Attributes, requireAll)
+	return append(violations, s.checkValues(PatchTrait, s.Traits, u.Traits, requireAll)...)
+}
+
+func (s *Schema) checkValues(field PatchField, fields map[string]FieldSchema, values map[string]interface{}, requireAll bool) []SchemaViolation {
+	var violations []SchemaViolation
+	for _, key := range sortedKeys(values) {
+		violations = append(violations, s.checkValue(field, fields, key, values[key])...)
+	}
+	if requireAll {
+		for _, key := range sortedKeys(fields) {
+			if _, ok := values[key]; fields[key].Required && !ok {
+				violations = append(violations, SchemaViolation{field, key, "is required"})
+			}
+		}
+	}
+	return violations
+}
+
+func (s *Schema) checkValue(field PatchField, fields map[string]FieldSchema, key string, value interface{}) []SchemaViolation {
+	fs, ok := fields[key]
+	if !ok {
+		problem := "is not in the schema"
+		if similar := similarKey(fields, key); similar != "" {
+			problem += fmt.Sprintf(" (did you mean %q?)", similar)
+		}
+		return []SchemaViolation{{field, key, problem}}
+	}
+
+	var violations []SchemaViolation
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, SchemaViolation{field, key, fmt.Sprintf(format, args...)})
+	}
+
+	// Check the value as it will be stored.
+	encoded, err := LargeIntegersAsStrings.encodeValue(value)
+	if err != nil {
+		violate("cannot be encoded: %v", err)
+		return violations
+	}
+	stored := encoded.AsInterface()
+
+	if !matchesType(fs.Type, value, stored) {
+		violate("must be of type %s, got %T", fs.Type, value)
+	}
+	if len(fs.Enum) > 0 {
+		allowed := false
+		for _, e := range fs.Enum {
+			if equalValues(value, e) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			violate("%v is not one of %v", stored, fs.Enum)
+		}
+	}
+	if fs.Pattern != "" {
+		str, isString := stored.(string)
+		re, err := s.pattern(fs.Pattern)
+		switch {
+		case err != nil:
+			violate("invalid pattern: %v", err)
+		case !isString:
+			violate("pattern %q needs a string value", fs.Pattern)
+		case !re.MatchString(str):
+			violate("%q does not match %q", str, fs.Pattern)
+		}
+	}
+	return violations
+}
+
+// matchesType reports whether value, stored as stored, is of type t.
+func matchesType(t FieldType, value, stored interface{}) bool {
+	switch t {
+	case TypeAny:
+		return true
+	case TypeString:
+		return isStringKind(value)
+	case TypeNumber, TypeInteger:
+		switch v := stored.(type) {
+		case float64:
+			return t == TypeNumber || v == math.Trunc(v)
+		case string:
+			// Integers beyond 2^53 are stored as decimal strings.
+			return isNumericString(value, stored)
+		}
+		return false
+	case TypeBool:
+		_, ok := stored.(bool)
+		return ok
+	case TypeTime:
+		if _, ok := value.(time.Time); ok {
+			return true
+		}
+		str, ok := stored.(string)
+		if !ok {
+			return false
+		}
+		_, err := time.Parse(time.RFC3339Nano, str)
+		return err == nil
+	case TypeList:
+		_, ok := stored.([]interface{})
+		return ok
+	case TypeObject:
+		_, ok := stored.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+// isStringKind reports whether value is a string or a named string type.
+func isStringKind(value interface{}) bool {
+	return value != nil && reflect.ValueOf(value).Kind() == reflect.String
+}
+
+// isNumericString reports whether stored is the decimal string an integer
+// value was stored as.
+func isNumericString(value, stored interface{}) bool {
+	str, ok := stored.(string)
+	if !ok || isStringKind(value) {
+		return false
+	}
+	_, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		_, err = strconv.ParseUint(str, 10, 64)
+	}
+	return err == nil
+}
+
+// similarKey returns the declared key that differs from key only in case,
+// underscores or dashes.
+func similarKey(fields map[string]FieldSchema, key string) string {
+	norm := func(s string) string {
+		return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(s))
+	}
+	want := norm(key)
+	for _, k := range sortedKeys(fields) {
+		if norm(k) == want {
+			return k
+		}
+	}
+	return ""
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SchemaMode selects what happens to calls that break a Schema.
+type SchemaMode int
+
+const (
+	// SchemaStrict rejects the call with an error matching
+	// ErrInvalidArgument.
+	SchemaStrict SchemaMode = iota
+	// SchemaWarn logs the violations with slog.Default() and makes the call.
+	SchemaWarn
+)
+
+// WithSchema checks the values passed to AddUser, UpdateUser, AddAttribute
+// and AddTrait against schema. Keys marked required are only enforced by
+// AddUser, since the other calls change some keys of an existing user.
+func WithSchema(schema *Schema, mode SchemaMode) ClientOption {
+	return func(o *clientOptions) {
+		o.schema = schema
+		o.schemaMode = mode
+	}
+}
+
+// enforceSchema applies the configured schema mode to the result of a
+// schema check for method.
+func (o clientOptions) enforceSchema(ctx context.Context, method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if o.schemaMode == SchemaWarn {
+		slog.Default().WarnContext(ctx, "userup: schema violation", "method", method, "error", err)
+		return nil
+	}
+	return invalidArgument(method, err)
+}
+
+// checkUser checks u against the configured schema, if any.
+func (o clientOptions) checkUser(ctx context.Context, method string, u *User, requireAll bool) error {
+	if o.schema == nil {
+		return nil
+	}
+	return o.enforceSchema(ctx, method, schemaError(o.schema.checkUser(u, requireAll)))
+}
+
+// checkValue checks a single attribute or trait against the configured
+// schema, if any.
+func (o clientOptions) checkValue(ctx context.Context, method string, field PatchField, key string, value interface{}) error {
+	if o.schema == nil {
+		return nil
+	}
+	fields := o.schema.Attributes
+	if field == PatchTrait {
+		fields = o.schema.Traits
+	}
+	return o.enforceSchema(ctx, method, schemaError(o.schema.checkValue(field, fields, key, value)))
+}
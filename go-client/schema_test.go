@@ -0,0 +1,316 @@
+package userup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+const testSchemaYAML = `
+attributes:
+  email:
+    type: string
+    required: true
+    pattern: '^[^@]+@[^@]+$'
+  level:
+    type: integer
+    enum: [1, 2, 3]
+  user_type:
+    enum: [staff, customer]
+traits:
+  seen_at:
+    type: time
+`
+
+const testSchemaJSON = `{
+  "attributes": {
+    "email": {"type": "string", "required": true, "pattern": "^[^@]+@[^@]+$"},
+    "level": {"type": "integer", "enum": [1, 2, 3]},
+    "user_type": {"enum": ["staff", "customer"]}
+  },
+  "traits": {
+    "seen_at": {"type": "time"}
+  }
+}`
+
+func writeSchema(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSchema(t *testing.T) {
+	for _, name := range []string{"schema.yaml", "schema.json"} {
+		t.Run(name, func(t *testing.T) {
+			data := testSchemaYAML
+			if strings.HasSuffix(name, ".json") {
+				data = testSchemaJSON
+			}
+			s, err := LoadSchema(writeSchema(t, name, data))
+			if err != nil {
+				t.Fatalf("LoadSchema: %v", err)
+			}
+			if got := sortedKeys(s.Attributes); !reflect.DeepEqual(got, []string{"email", "level", "user_type"}) {
+				t.Errorf("attributes = %v", got)
+			}
+			if email := s.Attributes["email"]; email.Type != TypeString || !email.Required || email.Pattern != "^[^@]+@[^@]+$" {
+				t.Errorf("email = %+v", email)
+			}
+			if got := s.Traits["seen_at"].Type; got != TypeTime {
+				t.Errorf("seen_at type = %q, want time", got)
+			}
+
+			// YAML reads enum numbers as ints and JSON as float64; both
+			// must match numbers of any Go type.
+			for _, v := range []interface{}{2, int64(2), uint8(2), 2.0} {
+				if err := s.ValidateAttribute("level", v); err != nil {
+					t.Errorf("level %T %v: %v", v, v, err)
+				}
+			}
+			for _, v := range []interface{}{4, 2.5, "2"} {
+				if err := s.ValidateAttribute("level", v); err == nil {
+					t.Errorf("level %T %v was accepted", v, v)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name, data, msg string
+	}{
+		{"schema.yaml", "attributes:\n  email:\n    typ: string\n", "typ"},
+		{"schema.yml", "atributes: {}\n", "atributes"},
+		{"schema.json", `{"attributes": {"email": {"typ": "string"}}}`, "typ"},
+		{"schema.JSON", `{"atributes": {}}`, "atributes"},
+		{"schema.yaml", "traits:\n  plan:\n    type: text\n", `unknown type "text"`},
+		{"schema.json", `{"attributes": {"email": {"pattern": "("}}}`, "missing closing )"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.msg, func(t *testing.T) {
+			_, err := LoadSchema(writeSchema(t, tt.name, tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("LoadSchema error = %v, want one mentioning %q", err, tt.msg)
+			}
+		})
+	}
+	if _, err := LoadSchema(filepath.Join(t.TempDir(), "missing.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadSchema of a missing file: %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestSchemaValidateValue(t *testing.T) {
+	s := &Schema{Attributes: map[string]FieldSchema{
+		"name":      {Type: TypeString},
+		"score":     {Type: TypeNumber},
+		"count":     {Type: TypeInteger},
+		"active":    {Type: TypeBool},
+		"since":     {Type: TypeTime},
+		"tags":      {Type: TypeList},
+		"address":   {Type: TypeObject},
+		"code":      {Pattern: "^[A-Z]{3}$"},
+		"anything":  {},
+		"user_type": {},
+	}}
+	type level string
+	tests := []struct {
+		key     string
+		value   interface{}
+		problem string // Empty if the value is valid.
+	}{
+		{"name", "jane", ""},
+		{"name", level("gold"), ""},
+		{"name", 5, "must be of type string, got int"},
+		{"score", 2.5, ""},
+		{"score", uint64(math.MaxUint64), ""},
+		{"score", "2.5", "must be of type number"},
+		{"count", 7, ""},
+		{"count", 7.0, ""},
+		{"count", 7.5, "must be of type integer"},
+		{"count", int64(math.MaxInt64), ""},
+		{"count", "7", "must be of type integer"},
+		{"active", true, ""},
+		{"active", "true", "must be of type bool"},
+		{"since", time.Now(), ""},
+		{"since", "2024-05-01T12:00:00Z", ""},
+		{"since", "yesterday", "must be of type time"},
+		{"tags", []string{"a"}, ""},
+		{"tags", "a", "must be of type list"},
+		{"address", map[string]interface{}{"city": "x"}, ""},
+		{"address", struct{ City string }{"x"}, ""},
+		{"address", []int{1}, "must be of type object"},
+		{"code", "ABC", ""},
+		{"code", "abc", `"abc" does not match "^[A-Z]{3}$"`},
+		{"code", 123, "needs a string value"},
+		{"anything", nil, ""},
+		{"userType", "staff", `is not in the schema (did you mean "user_type"?)`},
+		{"User-Type", "staff", `did you mean "user_type"?`},
+		{"colour", "red", "is not in the schema"},
+	}
+	for _, tt := range tests {
+		err := s.ValidateAttribute(tt.key, tt.value)
+		if tt.problem == "" {
+			if err != nil {
+				t.Errorf("%s %T %v: %v", tt.key, tt.value, tt.value, err)
+			}
+			continue
+		}
+		var se *SchemaError
+		if !errors.As(err, &se) || len(se.Violations) != 1 {
+			t.Errorf("%s %T %v: error %v, want a *SchemaError with one violation", tt.key, tt.value, tt.value, err)
+			continue
+		}
+		if v := se.Violations[0]; v.Field != PatchAttribute || v.Key != tt.key || !strings.Contains(v.Problem, tt.problem) {
+			t.Errorf("%s %T %v: violation %v, want one mentioning %q", tt.key, tt.value, tt.value, v, tt.problem)
+		}
+	}
+	if strings.Contains(s.ValidateAttribute("colour", "red").Error(), "did you mean") {
+		t.Error("colour got a hint although no key is similar")
+	}
+}
+
+func TestSchemaValidateUser(t *testing.T) {
+	s, err := LoadSchema(writeSchema(t, "schema.yaml", testSchemaYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.ValidateUser(&User{
+		Attributes: map[string]interface{}{"level": 5},
+		Traits:     map[string]interface{}{"seenAt": "now"},
+	})
+	var se *SchemaError
+	if !errors.As(err, &se) {
+		t.Fatalf("ValidateUser error = %v, want a *SchemaError", err)
+	}
+	want := []string{
+		`attribute "level": 5 is not one of [1 2 3]`,
+		`attribute "email": is required`,
+		`trait "seenAt": is not in the schema (did you mean "seen_at"?)`,
+	}
+	var got []string
+	for _, v := range se.Violations {
+		got = append(got, v.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("violations =\n%q\nwant\n%q", got, want)
+	}
+	if err := s.ValidateUser(&User{Attributes: map[string]interface{}{"email": "a@b"}}); err != nil {
+		t.Errorf("ValidateUser of a valid user: %v", err)
+	}
+}
+
+// schemaServer accepts every call the schema checks guard and counts them.
+type schemaServer struct {
+	userapi.UnimplementedUsersServer
+	calls atomic.Int32
+}
+
+func (s *schemaServer) Create(ctx context.Context, req *userapi.NewUser) (*userapi.UserResponse, error) {
+	s.calls.Add(1)
+	return &userapi.UserResponse{Id: &userapi.UserID{Id: 1}, Username: req.Username}, nil
+}
+
+func (s *schemaServer) Update(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	s.calls.Add(1)
+	return &userapi.UserResponse{Id: req.Id, Username: req.Username}, nil
+}
+
+func (s *schemaServer) AddAttribute(ctx context.Context, req *userapi.AttributeRequest) (*userapi.AttributeResponse, error) {
+	s.calls.Add(1)
+	return &userapi.AttributeResponse{}, nil
+}
+
+func (s *schemaServer) AddTrait(ctx context.Context, req *userapi.TraitRequest) (*userapi.TraitResponse, error) {
+	s.calls.Add(1)
+	return &userapi.TraitResponse{}, nil
+}
+
+func TestWithSchema(t *testing.T) {
+	schema, err := LoadSchema(writeSchema(t, "schema.yaml", testSchemaYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	noEmail := &User{ID: UID(1), Username: "jane", Attributes: map[string]interface{}{"level": 2}}
+	tests := []struct {
+		name    string
+		call    func(ctx context.Context, us *UserService) error
+		wantErr bool
+	}{
+		{"AddUser without a required key", func(ctx context.Context, us *UserService) error {
+			_, err := us.AddUser(ctx, noEmail)
+			return err
+		}, true},
+		{"UpdateUser without a required key", func(ctx context.Context, us *UserService) error {
+			_, err := us.UpdateUser(ctx, noEmail)
+			return err
+		}, false},
+		{"UpdateUser with a bad value", func(ctx context.Context, us *UserService) error {
+			_, err := us.UpdateUser(ctx, &User{ID: UID(1), Username: "jane", Attributes: map[string]interface{}{"level": 9}})
+			return err
+		}, true},
+		{"AddAttribute with a bad value", func(ctx context.Context, us *UserService) error {
+			return us.AddAttribute(ctx, UID(1), "email", "not an address")
+		}, true},
+		{"AddTrait with an unknown key", func(ctx context.Context, us *UserService) error {
+			return us.AddTrait(ctx, UID(1), "seenAt", time.Now())
+		}, true},
+		{"AddAttribute with a good value", func(ctx context.Context, us *UserService) error {
+			return us.AddAttribute(ctx, UID(1), "email", "a@b")
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Run("strict", func(t *testing.T) {
+				srv := &schemaServer{}
+				client := startTestServer(t, srv, WithSchema(schema, SchemaStrict))
+				err := tt.call(context.Background(), client)
+				if !tt.wantErr {
+					if err != nil {
+						t.Fatalf("call failed: %v", err)
+					}
+					return
+				}
+				var se *SchemaError
+				if !errors.Is(err, ErrInvalidArgument) || !errors.As(err, &se) {
+					t.Fatalf("call error = %v, want ErrInvalidArgument wrapping a *SchemaError", err)
+				}
+				if n := srv.calls.Load(); n != 0 {
+					t.Errorf("server got %d calls, want none", n)
+				}
+			})
+			t.Run("warn", func(t *testing.T) {
+				var logs bytes.Buffer
+				defaultLogger := slog.Default()
+				slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+				t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+				srv := &schemaServer{}
+				client := startTestServer(t, srv, WithSchema(schema, SchemaWarn))
+				if err := tt.call(context.Background(), client); err != nil {
+					t.Fatalf("call failed: %v", err)
+				}
+				if n := srv.calls.Load(); n != 1 {
+					t.Errorf("server got %d calls, want 1", n)
+				}
+				if warned := strings.Contains(logs.String(), "schema violation"); warned != tt.wantErr {
+					t.Errorf("logged a warning: %v, want %v (%s)", warned, tt.wantErr, logs.String())
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package userup
+
+import (
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// listenTestServer serves srv on a local port until the test ends and
+// returns its address.
+func listenTestServer(t *testing.T, srv userapi.UsersServer, opts ...grpc.ServerOption) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(opts...)
+	userapi.RegisterUsersServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// newTestClient connects to uri with opts and closes the client when the
+// test ends.
+func newTestClient(t *testing.T, uri string, opts ...ClientOption) *UserService {
+	t.Helper()
+	client, err := NewClient(uri, opts...)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+// startTestServer serves srv on a local port and returns a client connected
+// to it with opts.
+func startTestServer(t *testing.T, srv userapi.UsersServer, opts ...ClientOption) *UserService {
+	t.Helper()
+	return newTestClient(t, listenTestServer(t, srv), opts...)
+}
@@ -0,0 +1,48 @@
+package userup
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// WithDefaultTimeout sets a timeout for calls made with a context that has
+// no deadline of its own. Calls that run out of time fail with an error
+// matching ErrTimeout.
+func WithDefaultTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.defaultTimeout = d
+	}
+}
+
+// WithMethodTimeout overrides the default timeout for one RPC, named by its
+// full gRPC method name, for example userapi.Users_QueryUsers_FullMethodName.
+// Like the default, it only applies when the context has no deadline.
+func WithMethodTimeout(method string, d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		if o.methodTimeouts == nil {
+			o.methodTimeouts = make(map[string]time.Duration)
+		}
+		o.methodTimeouts[method] = d
+	}
+}
+
+// timeoutInterceptor gives calls without a deadline the configured timeout.
+// The timeout covers every attempt of a retried call.
+func timeoutInterceptor(defaultTimeout time.Duration, methodTimeouts map[string]time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			timeout := defaultTimeout
+			if d, ok := methodTimeouts[method]; ok {
+				timeout = d
+			}
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
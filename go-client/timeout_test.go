@@ -0,0 +1,98 @@
+package userup
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// slowServer answers Get and Update after delay and records whether the
+// last call carried a deadline.
+type slowServer struct {
+	userapi.UnimplementedUsersServer
+	delay       time.Duration
+	hadDeadline atomic.Bool
+}
+
+func (s *slowServer) wait(ctx context.Context, id *userapi.UserID) (*userapi.UserResponse, error) {
+	_, ok := ctx.Deadline()
+	s.hadDeadline.Store(ok)
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &userapi.UserResponse{Id: id, Username: "u"}, nil
+}
+
+func (s *slowServer) Get(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	return s.wait(ctx, req.Id)
+}
+
+func (s *slowServer) Update(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	return s.wait(ctx, req.Id)
+}
+
+func TestTimeouts(t *testing.T) {
+	const short, long = 50 * time.Millisecond, 5 * time.Second
+	tests := []struct {
+		name         string
+		opts         []ClientOption
+		callDeadline time.Duration // Zero for a context without deadline.
+		call         func(ctx context.Context, us *UserService) error
+		wantMethod   string // Method of the expected timeout, empty for success.
+		wantDeadline bool   // Whether the server should see a deadline.
+	}{
+		{"no timeout", nil, 0, getUser, "", false},
+		{"default timeout", []ClientOption{WithDefaultTimeout(short)}, 0, getUser, userapi.Users_Get_FullMethodName, true},
+		{"caller deadline wins over default", []ClientOption{WithDefaultTimeout(short)}, long, getUser, "", true},
+		{"method timeout overrides default", []ClientOption{
+			WithDefaultTimeout(short), WithMethodTimeout(userapi.Users_Get_FullMethodName, long),
+		}, 0, getUser, "", true},
+		{"other methods keep the default", []ClientOption{
+			WithDefaultTimeout(short), WithMethodTimeout(userapi.Users_Get_FullMethodName, long),
+		}, 0, updateUser, userapi.Users_Update_FullMethodName, true},
+		{"method timeout without default", []ClientOption{
+			WithMethodTimeout(userapi.Users_Update_FullMethodName, short),
+		}, 0, updateUser, userapi.Users_Update_FullMethodName, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &slowServer{delay: 200 * time.Millisecond}
+			opts := append([]ClientOption{WithRetry(RetryPolicy{MaxAttempts: 1})}, tt.opts...)
+			client := startTestServer(t, srv, opts...)
+
+			ctx := context.Background()
+			if tt.callDeadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.callDeadline)
+				defer cancel()
+			}
+			err := tt.call(ctx, client)
+
+			if tt.wantMethod == "" {
+				if err != nil {
+					t.Fatalf("call failed: %v", err)
+				}
+			} else {
+				var e *Error
+				if !errors.As(err, &e) {
+					t.Fatalf("call error = %v (%T), want an *Error", err, err)
+				}
+				if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("call error %v does not match ErrTimeout and context.DeadlineExceeded", err)
+				}
+				if e.Method != tt.wantMethod {
+					t.Errorf("Method = %q, want %q", e.Method, tt.wantMethod)
+				}
+			}
+			if got := srv.hadDeadline.Load(); got != tt.wantDeadline {
+				t.Errorf("server saw a deadline: %v, want %v", got, tt.wantDeadline)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package userup
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// defaultTLSReloadInterval is how often certificate files are checked for
+// changes when TLSConfig.ReloadInterval is not set.
+const defaultTLSReloadInterval = 30 * time.Second
+
+// TLSConfig describes how to secure the connection to the user service.
+// Certificate files are re-read when they change on disk, so rotated
+// certificates are picked up by new connections without restarting.
+type TLSConfig struct {
+	CAFile         string        // PEM bundle of CAs used to verify the server. Empty means system roots.
+	CertFile       string        // PEM client certificate, for mutual TLS.
+	KeyFile        string        // PEM client private key, for mutual TLS.
+	ServerName     string        // Overrides the name used to verify the server certificate, by default the host of each address.
+	UseSystemRoots bool          // Trust system roots in addition to CAFile.
+	ReloadInterval time.Duration // How often files are checked for changes. Defaults to 30s.
+}
+
+// WithTLS secures the connection with TLS, or mutual TLS when a client
+// certificate and key are given.
+func WithTLS(cfg TLSConfig) ClientOption {
+	return func(o *clientOptions) {
+		o.tls = &cfg
+	}
+}
+
+// tlsCredentials builds gRPC transport credentials from cfg. The files are
+// loaded once up front so configuration errors surface when dialing.
+func tlsCredentials(cfg TLSConfig) (credentials.TransportCredentials, error) {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, fmt.Errorf("userup: both CertFile and KeyFile are required for mutual TLS")
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = defaultTLSReloadInterval
+	}
+
+	r := &tlsReloader{cfg: cfg}
+	conf, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	return &reloadingCredentials{
+		TransportCredentials: credentials.NewTLS(conf),
+		reloader:             r,
+	}, nil
+}
+
+// tlsReloader keeps a tls.Config in sync with the files named in a TLSConfig.
+type tlsReloader struct {
+	cfg TLSConfig
+
+	mu        sync.Mutex
+	conf      *tls.Config
+	modTimes  map[string]time.Time
+	lastCheck time.Time
+}
+
+// config returns the current tls.Config, reloading the files first if the
+// reload interval has passed and any of them changed. When a reload fails the
+// previous configuration is kept.
+func (r *tlsReloader) config() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) < r.cfg.ReloadInterval {
+		return r.conf.Clone()
+	}
+	r.lastCheck = time.Now()
+
+	if r.changed() {
+		if conf, modTimes, err := r.read(); err == nil {
+			r.conf = conf
+			r.modTimes = modTimes
+		}
+	}
+	return r.conf.Clone()
+}
+
+// load reads the files for the first time.
+func (r *tlsReloader) load() (*tls.Config, error) {
+	conf, modTimes, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conf = conf
+	r.modTimes = modTimes
+	r.lastCheck = time.Now()
+	return conf.Clone(), nil
+}
+
+// changed reports whether any of the watched files has a different
+// modification time than when it was last read.
+func (r *tlsReloader) changed() bool {
+	for name, modTime := range r.modTimes {
+		fi, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		if !fi.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// read builds a tls.Config from the files on disk.
+func (r *tlsReloader) read() (*tls.Config, map[string]time.Time, error) {
+	conf := &tls.Config{
+		ServerName: r.cfg.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	modTimes := make(map[string]time.Time)
+
+	if r.cfg.CAFile != "" {
+		pool := x509.NewCertPool()
+		if r.cfg.UseSystemRoots {
+			sys, err := x509.SystemCertPool()
+			if err != nil {
+				return nil, nil, fmt.Errorf("userup: loading system roots: %w", err)
+			}
+			pool = sys
+		}
+
+		pem, modTime, err := readFile(r.cfg.CAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("userup: no certificates found in %s", r.cfg.CAFile)
+		}
+		conf.RootCAs = pool
+		modTimes[r.cfg.CAFile] = modTime
+	}
+
+	if r.cfg.CertFile != "" {
+		certPEM, certModTime, err := readFile(r.cfg.CertFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyPEM, keyModTime, err := readFile(r.cfg.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, nil, fmt.Errorf("userup: loading client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+		modTimes[r.cfg.CertFile] = certModTime
+		modTimes[r.cfg.KeyFile] = keyModTime
+	}
+
+	return conf, modTimes, nil
+}
+
+func readFile(name string) ([]byte, time.Time, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("userup: %w", err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("userup: %w", err)
+	}
+	return data, fi.ModTime(), nil
+}
+
+// reloadingCredentials performs every client handshake with the latest
+// configuration from its reloader.
+type reloadingCredentials struct {
+	credentials.TransportCredentials
+	reloader *tlsReloader
+}
+
+func (c *reloadingCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return credentials.NewTLS(c.reloader.config()).ClientHandshake(ctx, authority, conn)
+}
+
+func (c *reloadingCredentials) Clone() credentials.TransportCredentials {
+	return &reloadingCredentials{
+		TransportCredentials: c.TransportCredentials.Clone(),
+		reloader:             c.reloader,
+	}
+}
@@ -0,0 +1,261 @@
+package userup
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a PEM certificate and key for name, valid for both server
+// and client authentication.
+func (ca *testCA) issue(t *testing.T, name string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFile writes data to name and moves its modification time forward so
+// the change is seen even on file systems with coarse timestamps.
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	var modTime time.Time
+	if fi, err := os.Stat(name); err == nil {
+		modTime = fi.ModTime()
+	}
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if next := time.Now(); !next.After(modTime) {
+		modTime = modTime.Add(time.Second)
+	} else {
+		modTime = next
+	}
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serverTLS returns a server configuration with a certificate for
+// localhost and 127.0.0.1 that requires client certificates signed by ca.
+func (ca *testCA) serverTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, "localhost")
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+}
+
+// clientTLS writes the CA and a client certificate for name into dir and
+// returns the TLSConfig that uses them.
+func (ca *testCA) clientTLS(t *testing.T, dir, name string) TLSConfig {
+	t.Helper()
+	cfg := TLSConfig{
+		CAFile:         filepath.Join(dir, "ca.pem"),
+		CertFile:       filepath.Join(dir, "client.pem"),
+		KeyFile:        filepath.Join(dir, "client.key"),
+		ReloadInterval: 10 * time.Millisecond,
+	}
+	writeFile(t, cfg.CAFile, ca.pem)
+	certPEM, keyPEM := ca.issue(t, name)
+	writeFile(t, cfg.CertFile, certPEM)
+	writeFile(t, cfg.KeyFile, keyPEM)
+	return cfg
+}
+
+// peerServer answers Get with a user named after the client certificate of
+// the connection the call came in on.
+type peerServer struct {
+	userapi.UnimplementedUsersServer
+}
+
+func (peerServer) Get(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Internal, "no peer")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "no client certificate")
+	}
+	return &userapi.UserResponse{Id: req.Id, Username: info.State.PeerCertificates[0].Subject.CommonName}, nil
+}
+
+// listenTLSServer serves peerServer over mutual TLS. Connections are closed
+// after a short while, so clients keep making new ones.
+func listenTLSServer(t *testing.T, ca *testCA) string {
+	t.Helper()
+	return listenTestServer(t, peerServer{},
+		grpc.Creds(credentials.NewTLS(ca.serverTLS(t))),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionAge:      100 * time.Millisecond,
+			MaxConnectionAgeGrace: 100 * time.Millisecond,
+		}),
+	)
+}
+
+// clientName returns the client certificate name the server saw for a call.
+func clientName(t *testing.T, client *UserService) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	user, err := client.GetUser(ctx, UID(1))
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	return user.Username
+}
+
+func TestTLSReloadsRotatedClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	addr := listenTLSServer(t, ca)
+	cfg := ca.clientTLS(t, t.TempDir(), "client-1")
+	cfg.ServerName = "localhost"
+	client := newTestClient(t, addr, WithTLS(cfg))
+
+	if got := clientName(t, client); got != "client-1" {
+		t.Fatalf("first call used %q, want client-1", got)
+	}
+
+	certPEM, keyPEM := ca.issue(t, "client-2")
+	writeFile(t, cfg.CertFile, certPEM)
+	writeFile(t, cfg.KeyFile, keyPEM)
+	deadline := time.Now().Add(5 * time.Second)
+	for clientName(t, client) != "client-2" {
+		if time.Now().After(deadline) {
+			t.Fatal("new connections never presented the rotated certificate")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// A broken file keeps the last good configuration across reconnects.
+	writeFile(t, cfg.CertFile, []byte("not a certificate"))
+	for end := time.Now().Add(500 * time.Millisecond); time.Now().Before(end); {
+		if got := clientName(t, client); got != "client-2" {
+			t.Fatalf("after a bad rotation the call used %q, want client-2", got)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestTLSCredentialsErrors(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	writeFile(t, caFile, ca.pem)
+	certPEM, _ := ca.issue(t, "client")
+	_, otherKeyPEM := ca.issue(t, "other")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, otherKeyPEM)
+	emptyFile := filepath.Join(dir, "empty.pem")
+	writeFile(t, emptyFile, nil)
+
+	tests := []struct {
+		name string
+		cfg  TLSConfig
+	}{
+		{"cert without key", TLSConfig{CertFile: certFile}},
+		{"missing CA file", TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}},
+		{"CA file without certificates", TLSConfig{CAFile: emptyFile}},
+		{"mismatched key", TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tlsCredentials(tt.cfg); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package userup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// QueryUsersUnion returns the users matching any of queries, such as the
+// union ParseQueries returns. The queries must share their Select, OrderBy,
+// Limit and Offset, which QueryUsersUnion applies to the merged results:
+// each query is run for the first Offset+Limit users, users found by more
+// than one query are kept once, and the merged list is sorted, then cut to
+// Offset and Limit.
+//
+// A union can be ordered by id, username, attributes.key and traits.key.
+// Users missing an attribute or trait sort after the others in ascending
+// order, and numbers before strings. Without OrderBy, users are returned in
+// the order the queries found them.
+func (us UserService) QueryUsersUnion(ctx context.Context, queries []*Query) ([]*User, error) {
+	const method = userapi.Users_QueryUsers_FullMethodName
+	if len(queries) == 0 {
+		return nil, invalidArgument(method, errors.New("union of no queries"))
+	}
+	if len(queries) == 1 {
+		return us.QueryUsers(ctx, queries[0])
+	}
+	first := queries[0]
+	for i, q := range queries {
+		if q == nil {
+			return nil, invalidArgument(method, fmt.Errorf("query %d of the union is nil", i))
+		}
+		if !reflect.DeepEqual(q.Select, first.Select) || !reflect.DeepEqual(q.OrderBy, first.OrderBy) ||
+			q.Limit != first.Limit || q.Offset != first.Offset {
+			return nil, invalidArgument(method, fmt.Errorf("query %d of the union differs in select, order by, limit or offset", i))
+		}
+	}
+	for _, o := range first.OrderBy {
+		if _, ok := orderValue(&User{}, o.Field); !ok {
+			return nil, invalidArgument(method, fmt.Errorf("cannot order a union by %q", o.Field))
+		}
+	}
+
+	var users []*User
+	seen := make(map[UserID]bool)
+	for _, q := range queries {
+		sub := *q
+		sub.Offset = 0
+		if q.Limit > 0 {
+			sub.Limit = q.Limit + q.Offset
+		}
+		// Users are told apart by ID, so it must be selected.
+		if len(sub.Select) > 0 && !slices.Contains(sub.Select, "id") {
+			sub.Select = append(append([]string(nil), sub.Select...), "id")
+		}
+		found, err := us.QueryUsers(ctx, &sub)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range found {
+			if !seen[u.ID] {
+				seen[u.ID] = true
+				users = append(users, u)
+			}
+		}
+	}
+
+	if len(first.OrderBy) > 0 {
+		sort.SliceStable(users, func(i, j int) bool {
+			for _, o := range first.OrderBy {
+				a, _ := orderValue(users[i], o.Field)
+				b, _ := orderValue(users[j], o.Field)
+				c := compareOrderValues(a, b)
+				if strings.EqualFold(o.Direction, Desc) {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+
+	users = users[min(first.Offset, len(users)):]
+	if first.Limit > 0 && len(users) > first.Limit {
+		users = users[:first.Limit]
+	}
+	return users, nil
+}
+
+// orderValue returns the value of field for u, or false if a union cannot be
+// ordered by field.
+func orderValue(u *User, field string) (interface{}, bool) {
+	table, key, qualified := strings.Cut(field, ".")
+	if !qualified {
+		table, key = usersTable, field
+	}
+	switch table {
+	case usersTable:
+		switch key {
+		case "id":
+			return u.ID.ID, true
+		case "username":
+			return u.Username, true
+		}
+	case attributesTable:
+		return u.Attributes[key], true
+	case traitsTable:
+		return u.Traits[key], true
+	}
+	return nil, false
+}
+
+// compareOrderValues compares two attribute or trait values for sorting:
+// false before true, then numbers, then strings, then values of other types,
+// which compare equal, and missing values last.
+func compareOrderValues(a, b interface{}) int {
+	ra, rb := orderRank(a), orderRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	switch av := a.(type) {
+	case bool:
+		bv := b.(bool)
+		switch {
+		case av == bv:
+			return 0
+		case bv:
+			return -1
+		}
+		return 1
+	case string:
+		return strings.Compare(av, b.(string))
+	}
+	if af, ok := toFloat64(a); ok {
+		bf, _ := toFloat64(b)
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+	}
+	return 0
+}
+
+func orderRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 4
+	case bool:
+		return 0
+	case string:
+		return 2
+	}
+	if _, ok := toFloat64(v); ok {
+		return 1
+	}
+	return 3
+}
@@ -0,0 +1,127 @@
+package userup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// unionServer answers the nth QueryUsers call with the users whose IDs are
+// results[n], and records the queries it receives.
+type unionServer struct {
+	userapi.UnimplementedUsersServer
+	results [][]uint64
+	scores  map[uint64]interface{}
+	queries []Query
+}
+
+func (s *unionServer) QueryUsers(ctx context.Context, req *userapi.QueryRequest) (*userapi.UserListResponse, error) {
+	var q Query
+	if err := json.Unmarshal(req.Query, &q); err != nil {
+		return nil, err
+	}
+	resp := &userapi.UserListResponse{}
+	for _, id := range s.results[len(s.queries)] {
+		attrs := map[string]interface{}{}
+		if score, ok := s.scores[id]; ok {
+			attrs["score"] = score
+		}
+		pbAttrs, err := structpb.NewStruct(attrs)
+		if err != nil {
+			return nil, err
+		}
+		resp.Users = append(resp.Users, &userapi.UserResponse{Id: &userapi.UserID{Id: id}, Attributes: pbAttrs})
+	}
+	s.queries = append(s.queries, q)
+	return resp, nil
+}
+
+func userIDs(users []*User) []uint64 {
+	ids := make([]uint64, len(users))
+	for i, u := range users {
+		ids[i] = u.ID.ID
+	}
+	return ids
+}
+
+func TestQueryUsersUnion(t *testing.T) {
+	srv := &unionServer{results: [][]uint64{{5, 3, 1}, {4, 3, 2}}}
+	client := startTestServer(t, srv)
+
+	queries, err := ParseQueries("select username where attributes.vip_level >= 2 and (username ilike 'j%' or traits.plan in ('pro','team')) order by id desc limit 2 offset 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := client.QueryUsersUnion(context.Background(), queries)
+	if err != nil {
+		t.Fatalf("QueryUsersUnion: %v", err)
+	}
+	if got, want := userIDs(users), []uint64{4, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got users %v, want %v", got, want)
+	}
+	if len(srv.queries) != 2 {
+		t.Fatalf("server got %d queries, want 2", len(srv.queries))
+	}
+	for i, q := range srv.queries {
+		if q.Limit != 3 || q.Offset != 0 || !reflect.DeepEqual(q.Select, []string{"username", "id"}) {
+			t.Errorf("query %d selects %v with limit %d and offset %d, want [username id], 3 and 0", i, q.Select, q.Limit, q.Offset)
+		}
+	}
+	if !reflect.DeepEqual(queries[0].Select, []string{"username"}) {
+		t.Errorf("QueryUsersUnion changed the caller's query to select %v", queries[0].Select)
+	}
+}
+
+func TestQueryUsersUnionOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		orderBy []Order
+		want    []uint64
+	}{
+		{"query order", nil, []uint64{2, 1, 4, 3, 5}},
+		{"ascending", []Order{{Field: "attributes.score", Direction: "asc"}, {Field: "id", Direction: "desc"}}, []uint64{4, 3, 1, 2, 5}},
+		{"descending", []Order{{Field: "attributes.score", Direction: Desc}, {Field: "users.id", Direction: Asc}}, []uint64{5, 2, 1, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &unionServer{
+				results: [][]uint64{{2, 1}, {4, 1, 3, 5}},
+				scores:  map[uint64]interface{}{1: "a", 2: "b", 3: 10.0, 4: 9.5},
+			}
+			client := startTestServer(t, srv)
+			q := func() *Query { return &Query{OrderBy: tt.orderBy} }
+			users, err := client.QueryUsersUnion(context.Background(), []*Query{q(), q()})
+			if err != nil {
+				t.Fatalf("QueryUsersUnion: %v", err)
+			}
+			if got := userIDs(users); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got users %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryUsersUnionErrors(t *testing.T) {
+	srv := &unionServer{}
+	client := startTestServer(t, srv)
+	for name, queries := range map[string][]*Query{
+		"no queries":           nil,
+		"nil query":            {{}, nil},
+		"different limits":     {{Limit: 1}, {Limit: 2}},
+		"different selects":    {{Select: []string{"id"}}, {}},
+		"unsupported ordering": {{OrderBy: []Order{{Field: "created_at", Direction: Asc}}}, {OrderBy: []Order{{Field: "created_at", Direction: Asc}}}},
+	} {
+		if _, err := client.QueryUsersUnion(context.Background(), queries); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%s: QueryUsersUnion error = %v, want ErrInvalidArgument", name, err)
+		}
+	}
+	if len(srv.queries) != 0 {
+		t.Errorf("server got %d queries, want none", len(srv.queries))
+	}
+}
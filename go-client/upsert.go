@@ -0,0 +1,94 @@
+package userup
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// requireNaturalKey checks that id carries an identifier a new user can be
+// created with, an ExternalID or a UUID.
+func requireNaturalKey(id UserID) error {
+	if id.ExternalID == "" && id.UUID == uuid.Nil {
+		return invalidArgument(userapi.Users_Create_FullMethodName,
+			errors.New("user ExternalID or UUID is required"))
+	}
+	return nil
+}
+
+// GetOrCreateUser returns the user identified by id, creating it from
+// defaults if it does not exist yet. id must carry an ExternalID or a UUID,
+// which the new user is created with; the ID field of defaults is ignored.
+// The returned bool reports whether the user was created.
+//
+// If another caller creates the same user at the same time, GetOrCreateUser
+// reads and returns theirs.
+func (us UserService) GetOrCreateUser(ctx context.Context, id UserID, defaults *User) (*User, bool, error) {
+	if err := requireNaturalKey(id); err != nil {
+		return nil, false, err
+	}
+
+	user, err := us.GetUser(ctx, id)
+	if err == nil {
+		return user, false, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, false, err
+	}
+
+	newUser := &User{}
+	if defaults != nil {
+		*newUser = *defaults
+	}
+	newUser.ID = UserID{ExternalID: id.ExternalID, UUID: id.UUID}
+
+	user, err = us.AddUser(ctx, newUser)
+	if errors.Is(err, ErrAlreadyExists) {
+		user, err = us.GetUser(ctx, id)
+		return user, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return user, true, nil
+}
+
+// UpsertUser creates user if no user with its ExternalID or UUID exists, and
+// updates the existing one otherwise. user.ID must carry an ExternalID or a
+// UUID. The returned bool reports whether the user was created.
+//
+// If another caller creates the same user at the same time, UpsertUser
+// updates theirs instead.
+func (us UserService) UpsertUser(ctx context.Context, user *User) (*User, bool, error) {
+	if err := requireNaturalKey(user.ID); err != nil {
+		return nil, false, err
+	}
+
+	existing, err := us.GetUser(ctx, user.ID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		created, err := us.AddUser(ctx, user)
+		if err == nil {
+			return created, true, nil
+		}
+		if !errors.Is(err, ErrAlreadyExists) {
+			return nil, false, err
+		}
+		if existing, err = us.GetUser(ctx, user.ID); err != nil {
+			return nil, false, err
+		}
+	case err != nil:
+		return nil, false, err
+	}
+
+	update := *user
+	update.ID = existing.ID
+	updated, err := us.UpdateUser(ctx, &update)
+	if err != nil {
+		return nil, false, err
+	}
+	return updated, false, nil
+}
@@ -0,0 +1,162 @@
+package userup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/hillside-labs/userservice-go-sdk/pkg/userapi"
+)
+
+// upsertServer keeps users by external ID. With racer set, Create stores a
+// user named racer under the requested external ID and fails with
+// AlreadyExists, as if another caller had created it first.
+type upsertServer struct {
+	userapi.UnimplementedUsersServer
+	mu                     sync.Mutex
+	users                  map[string]*userapi.UserResponse
+	racer                  string
+	gets, creates, updates int
+}
+
+func (s *upsertServer) Get(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets++
+	if u, ok := s.users[req.Id.GetExternalId()]; ok {
+		return u, nil
+	}
+	return nil, status.Error(codes.NotFound, "no such user")
+}
+
+func (s *upsertServer) Create(ctx context.Context, req *userapi.NewUser) (*userapi.UserResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creates++
+	if s.racer != "" {
+		s.store(req.ExternalId, s.racer)
+		return nil, status.Error(codes.AlreadyExists, "user exists")
+	}
+	return s.store(req.ExternalId, req.Username), nil
+}
+
+func (s *upsertServer) Update(ctx context.Context, req *userapi.UserRequest) (*userapi.UserResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updates++
+	for ext, u := range s.users {
+		if u.Id.Id == req.Id.GetId() {
+			return s.store(ext, req.Username), nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "no such user")
+}
+
+func (s *upsertServer) store(externalID, username string) *userapi.UserResponse {
+	if s.users == nil {
+		s.users = make(map[string]*userapi.UserResponse)
+	}
+	u, ok := s.users[externalID]
+	if !ok {
+		u = &userapi.UserResponse{Id: &userapi.UserID{Id: uint64(len(s.users) + 1), ExternalId: externalID}}
+		s.users[externalID] = u
+	}
+	u.Username = username
+	return u
+}
+
+func TestGetOrCreateUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		existing    string // Username of an existing user, if any.
+		racer       string
+		wantName    string
+		wantCreated bool
+		wantCreates int
+	}{
+		{"existing user", "old", "", "old", false, 0},
+		{"new user", "", "", "default", true, 1},
+		{"created concurrently", "", "racer", "racer", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &upsertServer{racer: tt.racer}
+			if tt.existing != "" {
+				srv.store("ext-1", tt.existing)
+			}
+			client := startTestServer(t, srv)
+
+			user, created, err := client.GetOrCreateUser(context.Background(), UserID{ExternalID: "ext-1"}, &User{ID: UID(99), Username: "default"})
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+			if user.Username != tt.wantName || created != tt.wantCreated {
+				t.Errorf("got user %q, created %v, want %q, %v", user.Username, created, tt.wantName, tt.wantCreated)
+			}
+			if user.ID.ExternalID != "ext-1" || user.ID.ID != 1 {
+				t.Errorf("got ID %+v, want ID 1 with external ID ext-1", user.ID)
+			}
+			if srv.creates != tt.wantCreates || srv.updates != 0 {
+				t.Errorf("server got %d creates and %d updates, want %d and 0", srv.creates, srv.updates, tt.wantCreates)
+			}
+		})
+	}
+}
+
+func TestUpsertUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		existing    string
+		racer       string
+		wantCreated bool
+		wantCreates int
+		wantUpdates int
+	}{
+		{"existing user", "old", "", false, 0, 1},
+		{"new user", "", "", true, 1, 0},
+		{"created concurrently", "", "racer", false, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &upsertServer{racer: tt.racer}
+			if tt.existing != "" {
+				srv.store("ext-1", tt.existing)
+			}
+			client := startTestServer(t, srv)
+
+			user, created, err := client.UpsertUser(context.Background(), &User{ID: UserID{ExternalID: "ext-1"}, Username: "new"})
+			if err != nil {
+				t.Fatalf("UpsertUser: %v", err)
+			}
+			if user.Username != "new" || created != tt.wantCreated {
+				t.Errorf("got user %q, created %v, want new, %v", user.Username, created, tt.wantCreated)
+			}
+			if got := srv.users["ext-1"].Username; got != "new" {
+				t.Errorf("server holds %q, want new", got)
+			}
+			if len(srv.users) != 1 || srv.creates != tt.wantCreates || srv.updates != tt.wantUpdates {
+				t.Errorf("server holds %d users after %d creates and %d updates, want 1, %d and %d",
+					len(srv.users), srv.creates, srv.updates, tt.wantCreates, tt.wantUpdates)
+			}
+		})
+	}
+}
+
+func TestUpsertRequiresNaturalKey(t *testing.T) {
+	srv := &upsertServer{}
+	client := startTestServer(t, srv)
+
+	if _, _, err := client.GetOrCreateUser(context.Background(), UID(1), nil); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("GetOrCreateUser by numeric ID: %v, want ErrInvalidArgument", err)
+	}
+	if _, _, err := client.UpsertUser(context.Background(), &User{ID: UID(1), Username: "x"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("UpsertUser by numeric ID: %v, want ErrInvalidArgument", err)
+	}
+	if srv.gets != 0 {
+		t.Errorf("server got %d calls, want none", srv.gets)
+	}
+}
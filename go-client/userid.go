@@ -0,0 +1,135 @@
+package userup
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Prefixes of the canonical string form of a UserID.
+const (
+	userIDPrefix    = "id:"
+	userUUIDPrefix  = "uuid:"
+	userExtIDPrefix = "ext:"
+)
+
+// userIDSeparator joins the identifiers of a UserID that has more than one.
+const userIDSeparator = ","
+
+// String returns the canonical form of id: "id:42", "uuid:<uuid>" or
+// "ext:<external id>". When more than one identifier is set they are all
+// included, in that order and separated by commas, as in
+// "id:42,ext:auth0|1234". The external ID comes last, so it may itself
+// contain commas. An empty id is the empty string.
+func (id UserID) String() string {
+	var parts []string
+	if id.ID != 0 {
+		parts = append(parts, userIDPrefix+strconv.FormatUint(id.ID, 10))
+	}
+	if id.UUID != uuid.Nil {
+		parts = append(parts, userUUIDPrefix+id.UUID.String())
+	}
+	if id.ExternalID != "" {
+		parts = append(parts, userExtIDPrefix+id.ExternalID)
+	}
+	return strings.Join(parts, userIDSeparator)
+}
+
+// ParseUserID parses the canonical form produced by UserID.String.
+func ParseUserID(s string) (UserID, error) {
+	var id UserID
+	rest := s
+	if strings.HasPrefix(rest, userIDPrefix) {
+		var num string
+		num, rest = cutUserID(rest[len(userIDPrefix):])
+		n, err := strconv.ParseUint(num, 10, 64)
+		if err != nil || n == 0 {
+			return UserID{}, fmt.Errorf("userup: invalid numeric user ID %q", s)
+		}
+		id.ID = n
+	}
+	if strings.HasPrefix(rest, userUUIDPrefix) {
+		var str string
+		str, rest = cutUserID(rest[len(userUUIDPrefix):])
+		u, err := uuid.Parse(str)
+		if err != nil || u == uuid.Nil {
+			return UserID{}, fmt.Errorf("userup: invalid user UUID %q", s)
+		}
+		id.UUID = u
+	}
+	if strings.HasPrefix(rest, userExtIDPrefix) {
+		ext := rest[len(userExtIDPrefix):]
+		if ext == "" {
+			return UserID{}, fmt.Errorf("userup: empty external user ID %q", s)
+		}
+		id.ExternalID = ext
+		rest = ""
+	}
+	if rest != "" || id.IsZero() {
+		return UserID{}, fmt.Errorf("userup: user ID %q must be made of %q, %q and %q parts in that order", s, userIDPrefix, userUUIDPrefix, userExtIDPrefix)
+	}
+	return id, nil
+}
+
+// cutUserID splits s at the separator that ends the current identifier. A
+// separator must be followed by another identifier.
+func cutUserID(s string) (part, rest string) {
+	part, rest, found := strings.Cut(s, userIDSeparator)
+	if found && rest == "" {
+		// A trailing separator is malformed; keep something to report.
+		rest = userIDSeparator
+	}
+	return part, rest
+}
+
+// MarshalText implements encoding.TextMarshaler using the canonical form.
+func (id UserID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. Empty text yields an
+// empty UserID.
+func (id *UserID) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*id = UserID{}
+		return nil
+	}
+	parsed, err := ParseUserID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+// Value implements driver.Valuer. An empty UserID is stored as NULL.
+func (id UserID) Value() (driver.Value, error) {
+	if id.IsZero() {
+		return nil, nil
+	}
+	return id.String(), nil
+}
+
+// Scan implements sql.Scanner. It accepts the canonical string form, NULL,
+// and integers, which are taken as numeric IDs.
+func (id *UserID) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*id = UserID{}
+		return nil
+	case string:
+		return id.UnmarshalText([]byte(v))
+	case []byte:
+		return id.UnmarshalText(v)
+	case int64:
+		if v <= 0 {
+			return fmt.Errorf("userup: invalid numeric user ID %d", v)
+		}
+		*id = UID(uint64(v))
+		return nil
+	}
+	return fmt.Errorf("userup: cannot scan %T into UserID", src)
+}
@@ -0,0 +1,64 @@
+package userup
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestUserIDCanonicalForm(t *testing.T) {
+	u := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	tests := []struct {
+		id   UserID
+		text string
+	}{
+		{UID(42), "id:42"},
+		{UUID(u), "uuid:6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		{ExtID("auth0|1234"), "ext:auth0|1234"},
+		{UserID{ID: 4, ExternalID: "e"}, "id:4,ext:e"},
+		{UserID{ID: 4, UUID: u, ExternalID: "a,b"}, "id:4,uuid:6ba7b810-9dad-11d1-80b4-00c04fd430c8,ext:a,b"},
+		{UserID{UUID: u, ExternalID: "ext:x"}, "uuid:6ba7b810-9dad-11d1-80b4-00c04fd430c8,ext:ext:x"},
+	}
+	for _, tt := range tests {
+		if got := tt.id.String(); got != tt.text {
+			t.Errorf("String() = %q, want %q", got, tt.text)
+		}
+		parsed, err := ParseUserID(tt.text)
+		if err != nil {
+			t.Errorf("ParseUserID(%q): %v", tt.text, err)
+			continue
+		}
+		if parsed != tt.id {
+			t.Errorf("ParseUserID(%q) = %+v, want %+v", tt.text, parsed, tt.id)
+		}
+
+		data, err := json.Marshal(tt.id)
+		if err != nil {
+			t.Fatalf("json.Marshal(%+v): %v", tt.id, err)
+		}
+		var decoded UserID
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded != tt.id {
+			t.Errorf("JSON round trip of %+v gave %+v, %v", tt.id, decoded, err)
+		}
+	}
+}
+
+func TestParseUserIDErrors(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"42",
+		"id:",
+		"id:0",
+		"id:x",
+		"id:4,",
+		"id:4,,ext:e",
+		"ext:",
+		"uuid:nope",
+		"uuid:6ba7b810-9dad-11d1-80b4-00c04fd430c8,id:4",
+	} {
+		if id, err := ParseUserID(text); err == nil {
+			t.Errorf("ParseUserID(%q) = %+v, want an error", text, id)
+		}
+	}
+}
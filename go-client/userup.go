@@ -3,10 +3,13 @@ package userup
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/structpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
@@ -16,10 +19,13 @@ import (
 
 // NewClient creates a new instance of the UserService client.
 // It establishes a gRPC connection to the specified URI and returns the client.
-// The URI should be in the format "host:port".
+// The URI should be in the format "host:port", a comma separated list of
+// such addresses, or a gRPC target such as "dns:///host:port".
+// Options can be supplied to tune the underlying connection.
 // If the connection cannot be established, an error is returned.
-func NewClient(uri string) (*UserService, error) {
-	conn, err := grpc.Dial(uri, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func NewClient(uri string, opts ...ClientOption) (*UserService, error) {
+	o := newClientOptions(opts)
+	conn, err := dial(uri, o)
 	if err != nil {
 		return nil, err
 	}
@@ -27,8 +33,10 @@ func NewClient(uri string) (*UserService, error) {
 
 	return &UserService{
 		addr:   uri,
+		conn:   conn,
 		client: client,
 		close:  conn.Close,
+		opts:   o,
 	}, nil
 }
 
@@ -50,6 +58,19 @@ func ExtID(id string) UserID {
 	return UserID{ExternalID: id}
 }
 
+// IsZero reports whether none of the identifiers of id is set.
+func (id UserID) IsZero() bool {
+	return id.ID == 0 && id.UUID == uuid.Nil && id.ExternalID == ""
+}
+
+// requireUserID rejects an empty UserID before a call to method is made.
+func requireUserID(method string, id UserID) error {
+	if id.IsZero() {
+		return invalidArgument(method, errors.New("user ID is empty"))
+	}
+	return nil
+}
+
 type User struct {
 	ID         UserID
 	Username   string
@@ -112,8 +133,10 @@ type Event struct {
 
 type UserService struct {
 	addr   string
+	conn   *grpc.ClientConn
 	client userapi.UsersClient
 	close  func() error
+	opts   clientOptions
 }
 
 func (us *UserService) Close() {
@@ -124,53 +147,86 @@ func (us *UserService) Close() {
 // It takes a context and a pointer to a User struct as input.
 // It returns a pointer to the created User and an error, if any.
 func (us UserService) AddUser(ctx context.Context, user *User) (*User, error) {
+	if err := us.opts.checkUser(ctx, userapi.Users_Create_FullMethodName, user, true); err != nil {
+		return nil, err
+	}
 
-	attrStruct, err := structpb.NewStruct(user.Attributes)
+	attrStruct, err := us.opts.largeIntegers.encodeStruct(user.Attributes)
 	if err != nil {
-		return nil, err
+		return nil, invalidArgument(userapi.Users_Create_FullMethodName, err)
 	}
-	traitStruct, err := structpb.NewStruct(user.Traits)
+	traitStruct, err := us.opts.largeIntegers.encodeStruct(user.Traits)
 	if err != nil {
-		return nil, err
+		return nil, invalidArgument(userapi.Users_Create_FullMethodName, err)
 	}
-	userResp, err := us.client.Create(ctx, &userapi.NewUser{
+	newUser := &userapi.NewUser{
 		ExternalId: user.ID.ExternalID,
-		Uuid:       user.ID.UUID.String(),
 		Username:   user.Username,
 		Attributes: attrStruct,
 		Traits:     traitStruct,
-	})
+	}
+	if user.ID.UUID != uuid.Nil {
+		newUser.Uuid = user.ID.UUID.String()
+	}
+	userResp, err := us.client.Create(ctx, newUser)
 	if err != nil {
 		return nil, err
 	}
-	return UserResponseToUser(userResp), nil
+	return userFromResponse(userapi.Users_Create_FullMethodName, userResp)
 }
 
+// rpcUserID converts id for use in a request. Identifiers that are not set
+// are left out, and an empty id becomes nil.
 func rpcUserID(id UserID) *userapi.UserID {
-	return &userapi.UserID{
+	if id.IsZero() {
+		return nil
+	}
+	rpcID := &userapi.UserID{
 		Id:         id.ID,
-		Uuid:       id.UUID.String(),
 		ExternalId: id.ExternalID,
 	}
+	if id.UUID != uuid.Nil {
+		rpcID.Uuid = id.UUID.String()
+	}
+	return rpcID
 }
 
-func clientUserID(id *userapi.UserID) UserID {
-	return UserID{
+// clientUserID converts an identifier received from the server. A missing
+// UUID is left as uuid.Nil; a malformed one is an error.
+func clientUserID(id *userapi.UserID) (UserID, error) {
+	if id == nil {
+		return UserID{}, nil
+	}
+	userID := UserID{
 		ID:         id.Id,
-		UUID:       uuid.MustParse(id.Uuid),
 		ExternalID: id.ExternalId,
 	}
+	if id.Uuid != "" {
+		parsed, err := uuid.Parse(id.Uuid)
+		if err != nil {
+			return UserID{}, fmt.Errorf("invalid user UUID %q: %w", id.Uuid, err)
+		}
+		userID.UUID = parsed
+	}
+	return userID, nil
 }
 
 // AddAttribute adds an attribute to a user with the specified ID.
 // It takes a context, user ID, attribute key, and attribute value as parameters.
-// The attribute value can be of any type and will be converted to a structpb.Value.
+// The attribute value can be of any type; values structpb cannot hold directly,
+// such as time.Time or structs, are stored the way encoding/json writes them.
 // Returns an error if there was a problem adding the attribute.
 func (us UserService) AddAttribute(ctx context.Context, id UserID, key string, value interface{}) error {
-	attrVal, err := structpb.NewValue(value)
-	if err != nil {
+	if err := requireUserID(userapi.Users_AddAttribute_FullMethodName, id); err != nil {
+		return err
+	}
+	if err := us.opts.checkValue(ctx, userapi.Users_AddAttribute_FullMethodName, PatchAttribute, key, value); err != nil {
 		return err
 	}
+	attrVal, err := us.opts.largeIntegers.encodeValue(value)
+	if err != nil {
+		return invalidArgument(userapi.Users_AddAttribute_FullMethodName, err)
+	}
 	_, err = us.client.AddAttribute(ctx, &userapi.AttributeRequest{
 		UserId: rpcUserID(id),
 		Key:    key,
@@ -181,13 +237,19 @@ func (us UserService) AddAttribute(ctx context.Context, id UserID, key string, v
 
 // AddTrait adds a trait to a user identified by their ID.
 // It takes a context, user ID, trait key, and trait value as parameters.
-// The trait value can be of any type and will be converted to a structpb.Value.
+// The trait value can be of any type and is converted as for AddAttribute.
 // Returns an error if there was a problem adding the trait.
 func (us UserService) AddTrait(ctx context.Context, id UserID, key string, value interface{}) error {
-	traitVal, err := structpb.NewValue(value)
-	if err != nil {
+	if err := requireUserID(userapi.Users_AddTrait_FullMethodName, id); err != nil {
 		return err
 	}
+	if err := us.opts.checkValue(ctx, userapi.Users_AddTrait_FullMethodName, PatchTrait, key, value); err != nil {
+		return err
+	}
+	traitVal, err := us.opts.largeIntegers.encodeValue(value)
+	if err != nil {
+		return invalidArgument(userapi.Users_AddTrait_FullMethodName, err)
+	}
 	_, err = us.client.AddTrait(ctx, &userapi.TraitRequest{
 		UserId: rpcUserID(id),
 		Key:    key,
@@ -200,14 +262,20 @@ func (us UserService) AddTrait(ctx context.Context, id UserID, key string, value
 // It takes a context.Context and a pointer to a User struct as input.
 // It returns a pointer to the updated User struct and an error if any.
 func (us UserService) UpdateUser(ctx context.Context, user *User) (*User, error) {
-	attrStruct, err := structpb.NewStruct(user.Attributes)
-	if err != nil {
+	if err := requireUserID(userapi.Users_Update_FullMethodName, user.ID); err != nil {
+		return nil, err
+	}
+	if err := us.opts.checkUser(ctx, userapi.Users_Update_FullMethodName, user, false); err != nil {
 		return nil, err
 	}
+	attrStruct, err := us.opts.largeIntegers.encodeStruct(user.Attributes)
+	if err != nil {
+		return nil, invalidArgument(userapi.Users_Update_FullMethodName, err)
+	}
 
-	traitStruct, err := structpb.NewStruct(user.Traits)
+	traitStruct, err := us.opts.largeIntegers.encodeStruct(user.Traits)
 	if err != nil {
-		return nil, err
+		return nil, invalidArgument(userapi.Users_Update_FullMethodName, err)
 	}
 	userResp, err := us.client.Update(ctx, &userapi.UserRequest{
 		Id:         rpcUserID(user.ID),
@@ -218,20 +286,23 @@ func (us UserService) UpdateUser(ctx context.Context, user *User) (*User, error)
 	if err != nil {
 		return nil, err
 	}
-	return UserResponseToUser(userResp), nil
+	return userFromResponse(userapi.Users_Update_FullMethodName, userResp)
 }
 
 // GetUser retrieves a user by their ID.
 // It makes a request to the user service API to fetch the user details.
 // Returns the user object if found, otherwise returns an error.
 func (us UserService) GetUser(ctx context.Context, id UserID) (*User, error) {
+	if err := requireUserID(userapi.Users_Get_FullMethodName, id); err != nil {
+		return nil, err
+	}
 	userResp, err := us.client.Get(ctx, &userapi.UserRequest{
 		Id: rpcUserID(id),
 	})
 	if err != nil {
 		return nil, err
 	}
-	return UserResponseToUser(userResp), nil
+	return userFromResponse(userapi.Users_Get_FullMethodName, userResp)
 }
 
 func UserSearchToUserQuery(usp *UserSearchParams) *userapi.UserQuery {
@@ -250,15 +321,14 @@ func (us UserService) FindUser(ctx context.Context, usp *UserSearchParams) ([]*U
 	if err != nil {
 		return nil, err
 	}
-	users := make([]*User, 0, len(usersResp.Users))
-	for _, u := range usersResp.Users {
-		users = append(users, UserResponseToUser(u))
-	}
-	return users, nil
+	return usersFromResponse(userapi.Users_Find_FullMethodName, usersResp.Users)
 }
 
 // DeleteUser deletes a user by their ID.
 func (us UserService) DeleteUser(ctx context.Context, id UserID) error {
+	if err := requireUserID(userapi.Users_Delete_FullMethodName, id); err != nil {
+		return err
+	}
 	_, err := us.client.Delete(ctx, &userapi.UserRequest{
 		Id: rpcUserID(id),
 	})
@@ -266,6 +336,9 @@ func (us UserService) DeleteUser(ctx context.Context, id UserID) error {
 }
 
 func (us UserService) DeleteAttribute(ctx context.Context, userId UserID, key string) error {
+	if err := requireUserID(userapi.Users_DeleteAttribute_FullMethodName, userId); err != nil {
+		return err
+	}
 	_, err := us.client.DeleteAttribute(ctx, &userapi.AttributeRequest{
 		UserId: rpcUserID(userId),
 		Key:    key,
@@ -274,6 +347,9 @@ func (us UserService) DeleteAttribute(ctx context.Context, userId UserID, key st
 }
 
 func (us UserService) DeleteTrait(ctx context.Context, userId UserID, key string) error {
+	if err := requireUserID(userapi.Users_DeleteTrait_FullMethodName, userId); err != nil {
+		return err
+	}
 	_, err := us.client.DeleteTrait(ctx, &userapi.TraitRequest{
 		UserId: rpcUserID(userId),
 		Key:    key,
@@ -314,13 +390,7 @@ func (us UserService) GetUsersByTraits(ctx context.Context, names []string, begi
 		return nil, err
 	}
 
-	users := make([]*User, 0, len(usersResp.Users))
-
-	for _, u := range usersResp.Users {
-		users = append(users, UserResponseToUser(u))
-	}
-
-	return users, nil
+	return usersFromResponse(userapi.Users_GetUsersByTraits_FullMethodName, usersResp.Users)
 }
 
 func (us UserService) GetUsersByEvents(ctx context.Context, types []string, sources []string, schemas []string, begin time.Time, end time.Time) ([]*User, error) {
@@ -336,13 +406,7 @@ func (us UserService) GetUsersByEvents(ctx context.Context, types []string, sour
 		return nil, err
 	}
 
-	users := make([]*User, 0, len(usersResp.Users))
-
-	for _, u := range usersResp.Users {
-		users = append(users, UserResponseToUser(u))
-	}
-
-	return users, nil
+	return usersFromResponse(userapi.Users_GetUsersByEvents_FullMethodName, usersResp.Users)
 }
 
 func (us UserService) SearchEvents(ctx context.Context, userId UserID, types []string, begin time.Time, end time.Time) ([]Event, error) {
@@ -355,46 +419,136 @@ func (us UserService) SearchEvents(ctx context.Context, userId UserID, types []s
 	if err != nil {
 		return nil, err
 	}
-	events := make([]Event, len(eventsResp.Events))
-	for i, event := range eventsResp.Events {
-		events[i] = Event{
-			Timestamp:       event.Timestamp.AsTime(),
-			ID:              event.Id,
-			Source:          event.Source,
-			SpecVersion:     event.Specversion,
-			Type:            event.Type,
-			DataContentType: event.Datacontenttype,
-			DataSchema:      event.Dataschema,
-			Subject:         event.Subject,
-			Data:            event.Data,
-			UserID:          clientUserID(event.UserId),
-		}
-	}
-	return events, nil
+	return eventsFromResponse(userapi.Users_SearchEvents_FullMethodName, eventsResp.Events)
 }
 
+// UserResponseToUser converts a user returned by the server into a User.
+// A malformed UUID in the response is left out of the User's ID.
+//
+// Deprecated: Use UserResponseToUserErr, which reports malformed identifiers.
 func UserResponseToUser(userResp *userapi.UserResponse) *User {
+	user, err := UserResponseToUserErr(userResp)
+	if err != nil {
+		withoutUUID := proto.Clone(userResp).(*userapi.UserResponse)
+		withoutUUID.Id.Uuid = ""
+		user, _ = UserResponseToUserErr(withoutUUID)
+	}
+	return user
+}
+
+// UserResponseToUserErr converts a user returned by the server into a User.
+// It returns an error if the response carries a malformed identifier.
+func UserResponseToUserErr(userResp *userapi.UserResponse) (*User, error) {
+	id, err := clientUserID(userResp.Id)
+	if err != nil {
+		return nil, err
+	}
 	attributes := make(map[string]interface{})
-	for k, v := range userResp.Attributes.Fields {
+	for k, v := range userResp.GetAttributes().GetFields() {
 		attributes[k] = v.AsInterface()
 	}
 	traits := make(map[string]interface{})
-	for k, v := range userResp.Traits.Fields {
+	for k, v := range userResp.GetTraits().GetFields() {
 		traits[k] = v.AsInterface()
 	}
 	return &User{
-		ID:         clientUserID(userResp.Id),
+		ID:         id,
 		Username:   userResp.Username,
 		Attributes: attributes,
 		Traits:     traits,
+	}, nil
+}
+
+// userFromResponse converts the user returned by method, reporting a
+// conversion failure as an *Error.
+func userFromResponse(method string, userResp *userapi.UserResponse) (*User, error) {
+	user, err := UserResponseToUserErr(userResp)
+	if err != nil {
+		return nil, invalidResponse(method, err)
+	}
+	return user, nil
+}
+
+// usersFromResponse converts the users returned by method.
+func usersFromResponse(method string, usersResp []*userapi.UserResponse) ([]*User, error) {
+	users := make([]*User, 0, len(usersResp))
+	for _, u := range usersResp {
+		user, err := userFromResponse(method, u)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// eventFromProto converts an event returned by the server into an Event.
+func eventFromProto(event *userapi.Event) (Event, error) {
+	userID, err := clientUserID(event.UserId)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		Timestamp:       event.Timestamp.AsTime(),
+		ID:              event.Id,
+		Source:          event.Source,
+		SpecVersion:     event.Specversion,
+		Type:            event.Type,
+		DataContentType: event.Datacontenttype,
+		DataSchema:      event.Dataschema,
+		Subject:         event.Subject,
+		Data:            event.Data,
+		UserID:          userID,
+		SessionKey:      event.SessionKey,
+	}, nil
+}
+
+// eventsFromResponse converts the events returned by method.
+func eventsFromResponse(method string, eventsResp []*userapi.Event) ([]Event, error) {
+	events := make([]Event, len(eventsResp))
+	for i, event := range eventsResp {
+		e, err := eventFromProto(event)
+		if err != nil {
+			return nil, invalidResponse(method, err)
+		}
+		events[i] = e
 	}
+	return events, nil
+}
+
+// encodeQuery validates query, or only its join conditions when
+// WithoutQueryValidation was given, and encodes it for a call to method. Order directions are sent in upper case.
+func (us UserService) encodeQuery(method string, query *Query) ([]byte, error) {
+	if query == nil {
+		return nil, invalidArgument(method, errors.New("query is required"))
+	}
+	validate := query.Validate
+	if us.opts.skipQueryValidation {
+		validate = query.validateJoinOn
+	}
+	if err := validate(); err != nil {
+		return nil, invalidArgument(method, err)
+	}
+	if len(query.OrderBy) > 0 {
+		q := *query
+		q.OrderBy = make([]Order, len(query.OrderBy))
+		for i, o := range query.OrderBy {
+			q.OrderBy[i] = Order{Field: o.Field, Direction: strings.ToUpper(o.Direction)}
+		}
+		query = &q
+	}
+	queryJson, err := json.Marshal(query)
+	if err != nil {
+		return nil, invalidArgument(method, err)
+	}
+	return queryJson, nil
 }
 
 // QueryUsers queries the user service with the given query and returns a list of users and an error, if any.
 // The query parameter specifies the criteria for filtering the users.
 // The returned list of users contains the user ID, username, UUID, attributes, and traits.
 func (us UserService) QueryUsers(ctx context.Context, query *Query) ([]*User, error) {
-	queryJson, err := json.Marshal(query)
+	queryJson, err := us.encodeQuery(userapi.Users_QueryUsers_FullMethodName, query)
 	if err != nil {
 		return nil, err
 	}
@@ -402,31 +556,14 @@ func (us UserService) QueryUsers(ctx context.Context, query *Query) ([]*User, er
 	if err != nil {
 		return nil, err
 	}
-	users := make([]*User, len(userResp.Users))
-	for i, user := range userResp.Users {
-		attributes := make(map[string]interface{})
-		for k, v := range user.Attributes.Fields {
-			attributes[k] = v.AsInterface()
-		}
-		traits := make(map[string]interface{})
-		for k, v := range user.Traits.Fields {
-			traits[k] = v.AsInterface()
-		}
-		users[i] = &User{
-			ID:         clientUserID(user.Id),
-			Username:   user.Username,
-			Attributes: attributes,
-			Traits:     traits,
-		}
-	}
-	return users, nil
+	return usersFromResponse(userapi.Users_QueryUsers_FullMethodName, userResp.Users)
 }
 
 // QueryAttributes queries the attributes of a user based on the provided query.
 // It takes a context.Context and a *Query as input parameters.
 // It returns a map[string]interface{} containing the attributes of the user and an error if any.
 func (us UserService) QueryAttributes(ctx context.Context, query *Query) (map[string]interface{}, error) {
-	queryJson, err := json.Marshal(query)
+	queryJson, err := us.encodeQuery(userapi.Users_QueryAttributes_FullMethodName, query)
 	if err != nil {
 		return nil, err
 	}
@@ -441,7 +578,7 @@ func (us UserService) QueryAttributes(ctx context.Context, query *Query) (map[st
 // It takes a context.Context and a *Query as input parameters.
 // It returns a map[string]interface{} containing the traits of the users and an error if any.
 func (us UserService) QueryTraits(ctx context.Context, query *Query) (map[string]interface{}, error) {
-	queryJson, err := json.Marshal(query)
+	queryJson, err := us.encodeQuery(userapi.Users_QueryTraits_FullMethodName, query)
 	if err != nil {
 		return nil, err
 	}
@@ -455,7 +592,7 @@ func (us UserService) QueryTraits(ctx context.Context, query *Query) (map[string
 // QueryEvents queries events based on the provided query parameters.
 // It returns a slice of Event objects and an error if any.
 func (us UserService) QueryEvents(ctx context.Context, query *Query) ([]Event, error) {
-	queryJson, err := json.Marshal(query)
+	queryJson, err := us.encodeQuery(userapi.Users_QueryEvents_FullMethodName, query)
 	if err != nil {
 		return nil, err
 	}
@@ -463,28 +600,13 @@ func (us UserService) QueryEvents(ctx context.Context, query *Query) ([]Event, e
 	if err != nil {
 		return nil, err
 	}
-	events := make([]Event, len(eventResp.Events))
-	for i, event := range eventResp.Events {
-		events[i] = Event{
-			Timestamp:       event.Timestamp.AsTime(),
-			ID:              event.Id,
-			Source:          event.Source,
-			SpecVersion:     event.Specversion,
-			Type:            event.Type,
-			DataContentType: event.Datacontenttype,
-			DataSchema:      event.Dataschema,
-			Subject:         event.Subject,
-			Data:            event.Data,
-			UserID:          clientUserID(event.UserId),
-		}
-	}
-	return events, nil
+	return eventsFromResponse(userapi.Users_QueryEvents_FullMethodName, eventResp.Events)
 }
 
 func (us UserService) AddSession(ctx context.Context, sessionKey string, sessionData map[string]interface{}) error {
-	obj, err := structpb.NewStruct(sessionData)
+	obj, err := us.opts.largeIntegers.encodeStruct(sessionData)
 	if err != nil {
-		return err
+		return invalidArgument(userapi.Users_AddSession_FullMethodName, err)
 	}
 
 	_, err = us.client.AddSession(ctx, &userapi.SessionRequest{
@@ -498,6 +620,9 @@ func (us UserService) AddSession(ctx context.Context, sessionKey string, session
 }
 
 func (us UserService) IdentifySession(ctx context.Context, sessionKey string, userID UserID) error {
+	if err := requireUserID(userapi.Users_IdentifySession_FullMethodName, userID); err != nil {
+		return err
+	}
 	_, err := us.client.IdentifySession(ctx, &userapi.IdentifySessionRequest{
 		SessionKey: []string{sessionKey},
 		UserId:     rpcUserID(userID),
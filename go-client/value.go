@@ -0,0 +1,146 @@
+package userup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// LargeIntegerPolicy selects what happens to integers that cannot be stored
+// exactly in an attribute, trait or session value. Values are kept as
+// float64 on the wire, which holds integers exactly only up to 2^53.
+type LargeIntegerPolicy int
+
+const (
+	// RejectLargeIntegers fails the call with ErrInvalidArgument.
+	RejectLargeIntegers LargeIntegerPolicy = iota
+	// LargeIntegersAsStrings stores such integers as decimal strings.
+	// DecodeValue, Attr and Trait turn them back into integers.
+	LargeIntegersAsStrings
+)
+
+// WithLargeIntegers sets how integers beyond 2^53 are handled. The default is
+// RejectLargeIntegers.
+func WithLargeIntegers(policy LargeIntegerPolicy) ClientOption {
+	return func(o *clientOptions) {
+		o.largeIntegers = policy
+	}
+}
+
+// encodeStruct converts a map of attribute, trait or session values into a
+// structpb.Struct. See encodeValue.
+func (p LargeIntegerPolicy) encodeStruct(m map[string]interface{}) (*structpb.Struct, error) {
+	fields := make(map[string]*structpb.Value, len(m))
+	for k, v := range m {
+		val, err := p.encodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		fields[k] = val
+	}
+	return &structpb.Struct{Fields: fields}, nil
+}
+
+// encodeValue converts v into a structpb.Value. Values structpb cannot take
+// directly, such as time.Time, []string, structs and named types, are
+// normalised by a JSON round trip, so they are stored the way encoding/json
+// would write them. Integers that would lose precision are handled according
+// to p.
+func (p LargeIntegerPolicy) encodeValue(v interface{}) (*structpb.Value, error) {
+	switch v := v.(type) {
+	case nil, bool, string, float32, float64:
+		return structpb.NewValue(v)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var plain interface{}
+	if err := dec.Decode(&plain); err != nil {
+		return nil, err
+	}
+	plain, err = p.normalizeNumbers(plain)
+	if err != nil {
+		return nil, err
+	}
+	return structpb.NewValue(plain)
+}
+
+// normalizeNumbers replaces the json.Numbers in v by float64, or applies p
+// to integers a float64 cannot represent exactly.
+func (p LargeIntegerPolicy) normalizeNumbers(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return p.normalizeNumber(v)
+	case []interface{}:
+		for i, item := range v {
+			n, err := p.normalizeNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = n
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			n, err := p.normalizeNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = n
+		}
+	}
+	return v, nil
+}
+
+func (p LargeIntegerPolicy) normalizeNumber(n json.Number) (interface{}, error) {
+	s := n.String()
+	if strings.ContainsAny(s, ".eE") {
+		return n.Float64()
+	}
+
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %s", s)
+	}
+	f, acc := new(big.Float).SetInt(i).Float64()
+	if acc == big.Exact {
+		return f, nil
+	}
+	if p == LargeIntegersAsStrings {
+		return s, nil
+	}
+	return nil, fmt.Errorf("integer %s cannot be stored without losing precision", s)
+}
+
+// isLargeIntegerString reports whether s is an integer as written by
+// LargeIntegersAsStrings: in canonical decimal form and too large for a
+// float64 to hold exactly.
+func isLargeIntegerString(s string) bool {
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok || i.String() != s {
+		return false
+	}
+	_, acc := new(big.Float).SetInt(i).Float64()
+	return acc != big.Exact
+}
+
+// DecodeValue converts v, an attribute, trait or session value as returned by
+// the server, into the value out points to. It reverses the conversions made
+// when values are sent: numbers are converted to the numeric kind of out,
+// decimal strings written by LargeIntegersAsStrings back to integers, RFC
+// 3339 strings to time.Time and maps to structs.
+func DecodeValue(v interface{}, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("userup: DecodeValue needs a non-nil pointer, got %T", out)
+	}
+	return decodeValue("value", v, rv.Elem())
+}
@@ -0,0 +1,62 @@
+package userup
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecodeValueIntegers(t *testing.T) {
+	tests := []struct {
+		in      interface{}
+		want    int64
+		wantErr bool
+	}{
+		{float64(42), 42, false},
+		{"9007199254740993", 9007199254740993, false},
+		{"-9007199254740993", -9007199254740993, false},
+		{"-9223372036854775808", 0, true}, // -2^63 is exact as a float64
+		{"42", 0, true},
+		{"9007199254740992", 0, true}, // 2^53 is stored as a number
+		{"+9007199254740993", 0, true},
+		{"09007199254740993", 0, true},
+		{"99999999999999999999", 0, true},
+		{"forty-two", 0, true},
+		{1.5, 0, true},
+	}
+	for _, tt := range tests {
+		var got int64
+		err := DecodeValue(tt.in, &got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("DecodeValue(%#v) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("DecodeValue(%#v) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeValueUnsignedFromString(t *testing.T) {
+	var got uint64
+	if err := DecodeValue("18446744073709551615", &got); err != nil || got != math.MaxUint64 {
+		t.Errorf("DecodeValue = %d, %v; want %d", got, err, uint64(math.MaxUint64))
+	}
+	if err := DecodeValue("7", &got); err == nil {
+		t.Error("DecodeValue accepted a small integer written as a string")
+	}
+}
+
+func TestLargeIntegersRoundTrip(t *testing.T) {
+	const big = int64(1)<<53 + 1
+	encoded, err := LargeIntegersAsStrings.encodeValue(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got int64
+	if err := DecodeValue(encoded.AsInterface(), &got); err != nil || got != big {
+		t.Errorf("round trip gave %d, %v; want %d", got, err, big)
+	}
+	if _, err := RejectLargeIntegers.encodeValue(big); err == nil {
+		t.Error("RejectLargeIntegers accepted an integer beyond 2^53")
+	}
+}
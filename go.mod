@@ -8,6 +8,7 @@ require (
 	github.com/urfave/cli/v2 v2.27.1
 	google.golang.org/grpc v1.61.0
 	google.golang.org/protobuf v1.33.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (